@@ -0,0 +1,307 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"time"
+)
+
+// The operations can be made without waiting for their results using the Async
+// variant of each method, such as GetStringAsync or IncrIntAsync, which returns
+// a future for the same result as the blocking method. The request has been
+// handed to the service or client before the method returns so requests made one
+// after another are applied in the order they were made, just as they would be
+// by the blocking methods. SendAsync sends any other request and returns a
+// future for its response, which FutureValue turns into a future for its value
+// as a Go type
+
+// SendAsync will send the request and return a future for the response. Any
+// values that the request writes are put in their canonical form first (see
+// Normalize)
+func (s *Sync) SendAsync(request *Request) *Future[*Response] {
+	f := newFuture[*Response]()
+	if err := normalizeRequest(request); err != nil {
+		f.complete(nil, err)
+		return f
+	}
+	select {
+	case s.RequestChannel <- request:
+	case <-request.Done:
+		f.complete(nil, contextError(request.Err()))
+		return f
+	}
+	go func() {
+		select {
+		case response := <-request.ResponseChannel:
+			f.complete(response, response.Err())
+		case <-request.Done:
+			f.complete(nil, contextError(request.Err()))
+		}
+	}()
+	return f
+}
+
+// FutureValue returns a future for the value of the response held by the future
+// as the type T, using the same rules as As
+func FutureValue[T any](f *Future[*Response]) *Future[T] {
+	value := newFuture[T]()
+	f.Then(func(response *Response, err error) {
+		if err != nil {
+			var t T
+			value.complete(t, err)
+			return
+		}
+		value.complete(As[T](response.value()))
+	})
+	return value
+}
+
+// normalizeRequest will put the values that the request writes in their
+// canonical form, as the blocking methods do before sending them
+func normalizeRequest(request *Request) error {
+	var err error
+	switch {
+	case request.Op == MWRITE:
+		for _, value := range request.Values {
+			if value != nil {
+				if value.Val, err = Normalize(value.Val); err != nil {
+					return err
+				}
+			}
+		}
+	case request.Value == nil:
+	case request.Op&(WRITE|CAS|PUBLISH|LPUSH|RPUSH|PAPPEND) != 0:
+		request.Value.Val, err = Normalize(request.Value.Val)
+	case request.Op&(HSET|PSET) != 0:
+		request.Value.Val, err = normalizeItem(request.Value.Val)
+	}
+	return err
+}
+
+// futureVersion returns a future for the version of the key held by the
+// response of the future
+func futureVersion(f *Future[*Response]) *Future[uint64] {
+	version := newFuture[uint64]()
+	f.Then(func(response *Response, err error) {
+		if response == nil {
+			version.complete(0, err)
+			return
+		}
+		version.complete(response.Version, err)
+	})
+	return version
+}
+
+// futureOf returns a future for the value held by the response of the future
+// once it has been converted, just as the blocking methods convert the sets
+func futureOf[T any](convert func(interface{}) (T, bool), f *Future[*Response]) *Future[T] {
+	value := newFuture[T]()
+	f.Then(func(response *Response, err error) {
+		var t T
+		if err != nil {
+			value.complete(t, err)
+			return
+		}
+		var ok bool
+		if t, ok = convert(response.value()); !ok {
+			err = generateError(WrongType, fmt.Sprintf("The value %v can not be held as the type %T", response.value(), t))
+		}
+		value.complete(t, err)
+	})
+	return value
+}
+
+// GetValueAsync returns a future for the value of the key
+func (s *Sync) GetValueAsync(key string) *Future[interface{}] {
+	return FutureValue[interface{}](s.SendAsync(NewReadRequest(key, NONE)))
+}
+
+// GetBoolAsync returns a future for the BOOL value of the key
+func (s *Sync) GetBoolAsync(key string) *Future[bool] {
+	return FutureValue[bool](s.SendAsync(NewReadRequest(key, BOOL)))
+}
+
+// GetIntAsync returns a future for the INT value of the key
+func (s *Sync) GetIntAsync(key string) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewReadRequest(key, INT)))
+}
+
+// GetFloatAsync returns a future for the FLOAT value of the key
+func (s *Sync) GetFloatAsync(key string) *Future[float64] {
+	return FutureValue[float64](s.SendAsync(NewReadRequest(key, FLOAT)))
+}
+
+// GetStringAsync returns a future for the STRING value of the key
+func (s *Sync) GetStringAsync(key string) *Future[string] {
+	return FutureValue[string](s.SendAsync(NewReadRequest(key, STRING)))
+}
+
+// GetArrayAsync returns a future for the ARRAY value of the key
+func (s *Sync) GetArrayAsync(key string) *Future[[]interface{}] {
+	return FutureValue[[]interface{}](s.SendAsync(NewReadRequest(key, ARRAY)))
+}
+
+// GetMapAsync returns a future for the MAP value of the key
+func (s *Sync) GetMapAsync(key string) *Future[map[string]interface{}] {
+	return FutureValue[map[string]interface{}](s.SendAsync(NewReadRequest(key, MAP)))
+}
+
+// GetSetAsync returns a future for the SET value of the key
+func (s *Sync) GetSetAsync(key string) *Future[Set] {
+	return futureOf(toSet, s.SendAsync(NewReadRequest(key, SET)))
+}
+
+// GetZSetAsync returns a future for the ZSET value of the key
+func (s *Sync) GetZSetAsync(key string) *Future[ZSet] {
+	return futureOf(toZSet, s.SendAsync(NewReadRequest(key, ZSET)))
+}
+
+// SetAsync will store the value of the type specified for the key (NONE for
+// any type) and return a future for the new version of the key
+func (s *Sync) SetAsync(key string, dType Type, value interface{}) *Future[uint64] {
+	return s.SetWithTTLAsync(key, dType, value, 0)
+}
+
+// SetWithTTLAsync will store the value of the type specified for the key that
+// will expire once the ttl has elapsed and return a future for the new version
+func (s *Sync) SetWithTTLAsync(key string, dType Type, value interface{}, ttl time.Duration) *Future[uint64] {
+	return futureVersion(s.SendAsync(NewWriteRequestWithTTL(key, dType, value, ttl)))
+}
+
+// CompareAndSwapAsync will store the value for the key only if the current
+// version matches the version provided and return a future for the new version
+func (s *Sync) CompareAndSwapAsync(key string, dType Type, value interface{}, version uint64) *Future[uint64] {
+	return futureVersion(s.SendAsync(NewCompareAndSwapRequest(key, dType, value, version)))
+}
+
+// IncrIntAsync will add the delta to the INT value of the key and return a
+// future for the new value
+func (s *Sync) IncrIntAsync(key string, delta int) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewIncrementRequest(key, INT, delta)))
+}
+
+// DecrIntAsync will subtract the delta from the INT value of the key and return
+// a future for the new value
+func (s *Sync) DecrIntAsync(key string, delta int) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewDecrementRequest(key, INT, delta)))
+}
+
+// IncrFloatAsync will add the delta to the FLOAT value of the key and return a
+// future for the new value
+func (s *Sync) IncrFloatAsync(key string, delta float64) *Future[float64] {
+	return FutureValue[float64](s.SendAsync(NewIncrementRequest(key, FLOAT, delta)))
+}
+
+// DecrFloatAsync will subtract the delta from the FLOAT value of the key and
+// return a future for the new value
+func (s *Sync) DecrFloatAsync(key string, delta float64) *Future[float64] {
+	return FutureValue[float64](s.SendAsync(NewDecrementRequest(key, FLOAT, delta)))
+}
+
+// LPushAsync will add the items to the start of the list and return a future
+// for the new length
+func (s *Sync) LPushAsync(key string, items ...interface{}) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewPushRequest(LPUSH, key, items...)))
+}
+
+// RPushAsync will add the items to the end of the list and return a future for
+// the new length
+func (s *Sync) RPushAsync(key string, items ...interface{}) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewPushRequest(RPUSH, key, items...)))
+}
+
+// LPopAsync will remove the first item of the list and return a future for it
+func (s *Sync) LPopAsync(key string) *Future[interface{}] {
+	return s.BLPopAsync(key, 0)
+}
+
+// RPopAsync will remove the last item of the list and return a future for it
+func (s *Sync) RPopAsync(key string) *Future[interface{}] {
+	return s.BRPopAsync(key, 0)
+}
+
+// BLPopAsync will remove the first item of the list, waiting until the timeout
+// for an item to be pushed if the list is empty, and return a future for it
+func (s *Sync) BLPopAsync(key string, timeout time.Duration) *Future[interface{}] {
+	return FutureValue[interface{}](s.SendAsync(NewPopRequest(LPOP, key, timeout)))
+}
+
+// BRPopAsync will remove the last item of the list, waiting until the timeout
+// for an item to be pushed if the list is empty, and return a future for it
+func (s *Sync) BRPopAsync(key string, timeout time.Duration) *Future[interface{}] {
+	return FutureValue[interface{}](s.SendAsync(NewPopRequest(RPOP, key, timeout)))
+}
+
+// LRangeAsync returns a future for the items from start to stop (inclusive)
+func (s *Sync) LRangeAsync(key string, start, stop int) *Future[[]interface{}] {
+	return FutureValue[[]interface{}](s.SendAsync(NewRangeRequest(key, start, stop)))
+}
+
+// LLenAsync returns a future for the length of the list
+func (s *Sync) LLenAsync(key string) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewLengthRequest(key)))
+}
+
+// HGetAsync returns a future for the value of the field of the map
+func (s *Sync) HGetAsync(key, field string) *Future[interface{}] {
+	return FutureValue[interface{}](s.SendAsync(NewFieldReadRequest(key, field)))
+}
+
+// HSetAsync will store the value for the field of the map and return a future
+// for the new version of the key
+func (s *Sync) HSetAsync(key, field string, value interface{}) *Future[uint64] {
+	return futureVersion(s.SendAsync(NewFieldWriteRequest(key, field, value)))
+}
+
+// HIncrIntAsync will add the delta to the INT value of the field and return a
+// future for the new value
+func (s *Sync) HIncrIntAsync(key, field string, delta int) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewFieldIncrementRequest(key, field, INT, delta)))
+}
+
+// SAddAsync will add the members to the set and return a future for the number
+// that were not already members
+func (s *Sync) SAddAsync(key string, members ...string) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewSetRequest(SADD, key, members...)))
+}
+
+// SRemAsync will remove the members from the set and return a future for the
+// number that were removed
+func (s *Sync) SRemAsync(key string, members ...string) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewSetRequest(SREM, key, members...)))
+}
+
+// SIsMemberAsync returns a future for whether the member is within the set
+func (s *Sync) SIsMemberAsync(key, member string) *Future[bool] {
+	return FutureValue[bool](s.SendAsync(NewIsMemberRequest(key, member)))
+}
+
+// SMembersAsync returns a future for the members of the set
+func (s *Sync) SMembersAsync(key string) *Future[Set] {
+	return futureOf(toSet, s.SendAsync(NewMembersRequest(key)))
+}
+
+// ZAddAsync will add the members to the sorted set and return a future for the
+// number that were added
+func (s *Sync) ZAddAsync(key string, members ...ZMember) *Future[int] {
+	return FutureValue[int](s.SendAsync(NewZAddRequest(key, members...)))
+}
+
+// ZScoreAsync returns a future for the score of the member of the sorted set
+func (s *Sync) ZScoreAsync(key, member string) *Future[float64] {
+	return FutureValue[float64](s.SendAsync(NewZMemberRequest(ZSCORE, key, member)))
+}
+
+// ZIncrByAsync will add the delta to the score of the member and return a
+// future for the new score
+func (s *Sync) ZIncrByAsync(key, member string, delta float64) *Future[float64] {
+	return FutureValue[float64](s.SendAsync(NewZIncrByRequest(key, member, delta)))
+}
+
+// ZRangeAsync returns a future for the members from the start to stop rank
+// (inclusive)
+func (s *Sync) ZRangeAsync(key string, start, stop int) *Future[ZSet] {
+	return futureOf(toZSet, s.SendAsync(NewZRangeRequest(key, start, stop)))
+}
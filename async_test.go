@@ -0,0 +1,95 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAsyncMethodsApplyInOrder(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+
+	// Each request is sent before the method returns so none wait for the others
+	var counts []*Future[int]
+	for i := 0; i < 100; i++ {
+		counts = append(counts, ks.IncrIntAsync("count", 1))
+	}
+	version := ks.SetAsync("name", STRING, "landon")
+	name := ks.GetStringAsync("name")
+	pushed := ks.RPushAsync("list", "a", "b")
+	popped := ks.LPopAsync("list")
+	added := ks.ZAddAsync("zset", ZMember{Member: "a", Score: 1})
+	score := ks.ZIncrByAsync("zset", "a", 1.5)
+	members := ks.GetZSetAsync("zset")
+
+	for i, count := range counts {
+		if val, err := count.Wait(); err != nil || val != i+1 {
+			t.Errorf("IncrIntAsync %d = %d, %v, expected %d", i, val, err, i+1)
+		}
+	}
+	if v, err := version.Wait(); err != nil || v == 0 {
+		t.Errorf("SetAsync = %d, %v, expected the new version", v, err)
+	}
+	if val, err := name.Wait(); err != nil || val != "landon" {
+		t.Errorf("GetStringAsync = %q, %v, expected landon", val, err)
+	}
+	if val, err := pushed.Wait(); err != nil || val != 2 {
+		t.Errorf("RPushAsync = %d, %v, expected 2", val, err)
+	}
+	if val, err := popped.Wait(); err != nil || val != "a" {
+		t.Errorf("LPopAsync = %v, %v, expected a", val, err)
+	}
+	if val, err := added.Wait(); err != nil || val != 1 {
+		t.Errorf("ZAddAsync = %d, %v, expected 1", val, err)
+	}
+	if val, err := score.Wait(); err != nil || val != 2.5 {
+		t.Errorf("ZIncrByAsync = %g, %v, expected 2.5", val, err)
+	}
+	if val, err := members.Wait(); err != nil || !reflect.DeepEqual(val, ZSet{{Member: "a", Score: 2.5}}) {
+		t.Errorf("GetZSetAsync = %v, %v, expected a with 2.5", val, err)
+	}
+}
+
+func TestSendAsyncNormalizesValues(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+
+	type point struct {
+		X int8
+		Y float32
+	}
+	writes := []Awaitable{
+		ks.SendAsync(NewWriteRequest("struct", NONE, point{X: 1, Y: 2.5})),
+		ks.SetAsync("int", NONE, int64(3)),
+		ks.RPushAsync("list", uint8(4), []interface{}{float32(0.5)}),
+		ks.HSetAsync("map", "field", map[string]interface{}{"a": int16(5)}),
+	}
+	if err := WaitAll(writes...); err != nil {
+		t.Fatalf("A write failed: %s", err)
+	}
+	expected := map[string]interface{}{
+		"struct": map[string]interface{}{"X": 1, "Y": 2.5},
+		"int":    3,
+		"list":   []interface{}{4, []interface{}{0.5}},
+		"map":    map[string]interface{}{"field": map[string]interface{}{"a": 5}},
+	}
+	for key, val := range expected {
+		if got, err := ks.GetValueAsync(key).Wait(); err != nil || !reflect.DeepEqual(got, val) {
+			t.Errorf("GetValueAsync(%s) = %#v, %v, expected %#v", key, got, err, val)
+		}
+	}
+
+	// A value that can not be normalized fails without being sent
+	if _, err := ks.SetAsync("invalid", NONE, uint64(1<<63)).Wait(); CodeOf(err) != Invalid {
+		t.Errorf("SetAsync returned %v, expected the value to be invalid", err)
+	}
+	if _, err := ks.GetValue("invalid"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue(invalid) returned %v, expected the value not to have been written", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBatchAcrossShards(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(4)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	values := make(map[string]interface{})
+	keys := make([]string, 0, 50)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("batch%02d", i)
+		values[key] = i
+		keys = append(keys, key)
+	}
+	if err := ks.MSet(INT, values); err != nil {
+		t.Fatalf("MSet: %s", err)
+	}
+	read, err := ks.MGet(INT, append(keys, "missing")...)
+	if err != nil {
+		t.Fatalf("MGet: %s", err)
+	}
+	for key, val := range values {
+		if read[key] != val {
+			t.Errorf("MGet returned %v for %s, expected %v", read[key], key, val)
+		}
+	}
+	if _, ok := read["missing"]; ok {
+		t.Error("MGet returned a value for a key that does not exist")
+	}
+
+	// A key of the wrong type does not stop the others being read
+	ks.SetString("batch00", "string")
+	read, err = ks.MGet(INT, keys...)
+	if err != nil || len(read) != len(keys)-1 {
+		t.Errorf("MGet returned %d values, %v, expected every key but the string", len(read), err)
+	}
+
+	if err := ks.MDelete(keys...); err != nil {
+		t.Fatalf("MDelete: %s", err)
+	}
+	for _, key := range keys {
+		if _, err := ks.GetValue(key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetValue(%s) returned %v after MDelete, expected it to be deleted", key, err)
+		}
+	}
+}
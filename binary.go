@@ -0,0 +1,283 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// The binary format begins with a header of the magic bytes, the version and
+// the sequence used for the versions of the keys (uvarint). The records follow
+// in blocks, each of which is written as
+//
+//	[record count (uvarint)][payload length (uvarint)][payload][crc32 of all before]
+//
+// and the file ends with an empty block. Each record within the payload is written as
+//
+//	[key length (uvarint)][key][type (uvarint)][version (uvarint)][expiry unix nanos (varint)][value length (uvarint)][value]
+//
+// with an expiry of zero meaning the key does not expire. Version 1 of the format
+// did not include the sequence or the versions of the keys.
+
+// binaryMagic identifies a snapshot written using the binary format
+var binaryMagic = []byte("KSDB")
+
+// binaryVersion is the current version of the binary format
+const binaryVersion byte = 2
+
+// binaryBlockSize is the size a block payload can grow to before it is written
+const binaryBlockSize = 64 * 1024
+
+// maxBinaryBlockSize protects against allocating a huge block from a corrupt length
+const maxBinaryBlockSize = 1 << 30
+
+// writeBinarySnapshot will write the records in blocks so that only a single
+// block is ever held in memory. Any write error is held by the writer
+func (s *Store) writeBinarySnapshot(w *bufio.Writer) error {
+	var block bytes.Buffer
+	w.Write(binaryMagic)
+	w.WriteByte(binaryVersion)
+	putUvarint(&block, s.sequence.current())
+	w.Write(block.Bytes())
+	block.Reset()
+	var count int
+	for _, part := range s.parts() {
+		for key, val := range part.values {
+			t := TypeOf(val)
+			b, err := encodeBinaryValue(t, val)
+			if err != nil {
+				return err
+			}
+			var expiry int64
+			if expires, ok := part.expires[key]; ok {
+				expiry = expires.UnixNano()
+			}
+			putUvarint(&block, uint64(len(key)))
+			block.WriteString(key)
+			putUvarint(&block, uint64(t))
+			putUvarint(&block, part.versions[key])
+			putVarint(&block, expiry)
+			putUvarint(&block, uint64(len(b)))
+			block.Write(b)
+			if count++; block.Len() >= binaryBlockSize {
+				writeBinaryBlock(w, count, block.Bytes())
+				block.Reset()
+				count = 0
+			}
+		}
+	}
+	if count > 0 {
+		writeBinaryBlock(w, count, block.Bytes())
+	}
+
+	// The empty block marks the end of the file
+	return writeBinaryBlock(w, 0, nil)
+}
+
+// writeBinaryBlock will write the block followed by its checksum
+func writeBinaryBlock(w *bufio.Writer, count int, payload []byte) error {
+	var header bytes.Buffer
+	putUvarint(&header, uint64(count))
+	putUvarint(&header, uint64(len(payload)))
+	crc := crc32.NewIEEE()
+	crc.Write(header.Bytes())
+	crc.Write(payload)
+	w.Write(header.Bytes())
+	w.Write(payload)
+	_, err := w.Write(crc.Sum(nil))
+	return err
+}
+
+// readBinarySnapshot will read and verify each block in turn
+func readBinarySnapshot(r *bufio.Reader, l *loader) error {
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	version := header[len(binaryMagic)]
+	if version == 0 || version > binaryVersion {
+		return generateError(Invalid, fmt.Sprintf("Unsupported binary snapshot version %d", version))
+	}
+	if version > 1 {
+		sequence, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		l.updateSequence(sequence)
+	}
+	for {
+		crc := crc32.NewIEEE()
+		tee := &teeByteReader{r, crc}
+		count, err := binary.ReadUvarint(tee)
+		if err != nil {
+			return err
+		}
+		length, err := binary.ReadUvarint(tee)
+		if err != nil {
+			return err
+		}
+		if length > maxBinaryBlockSize {
+			return generateError(Invalid, fmt.Sprintf("Binary snapshot block of %d bytes is too large", length))
+		}
+		payload := make([]byte, length)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return err
+		}
+		crc.Write(payload)
+		sum := make([]byte, crc32.Size)
+		if _, err = io.ReadFull(r, sum); err != nil {
+			return err
+		}
+		if !bytes.Equal(sum, crc.Sum(nil)) {
+			return generateError(Invalid, "Binary snapshot block failed the checksum")
+		}
+
+		// An empty block marks the end of the file
+		if count == 0 {
+			return nil
+		}
+		if err = readBinaryRecords(bytes.NewReader(payload), version, count, l); err != nil {
+			return err
+		}
+	}
+}
+
+// readBinaryRecords will decode the expected number of records from the block
+func readBinaryRecords(r *bytes.Reader, version byte, count uint64, l *loader) error {
+	for ; count > 0; count-- {
+		key, err := readBinaryBytes(r)
+		if err != nil {
+			return err
+		}
+		t, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		var keyVersion uint64
+		if version > 1 {
+			if keyVersion, err = binary.ReadUvarint(r); err != nil {
+				return err
+			}
+		}
+		expiry, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		b, err := readBinaryBytes(r)
+		if err != nil {
+			return err
+		}
+		val, err := decodeBinaryValue(Type(t), b)
+		if err != nil {
+			return err
+		}
+		var expiresAt *time.Time
+		if expiry != 0 {
+			e := time.Unix(0, expiry)
+			expiresAt = &e
+		}
+		l.add(string(key), val, expiresAt, keyVersion)
+	}
+	if r.Len() > 0 {
+		return generateError(Invalid, "Binary snapshot block has unexpected trailing data")
+	}
+	return nil
+}
+
+// readBinaryBytes will read a length prefixed slice of bytes
+func readBinaryBytes(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// encodeBinaryValue will encode the scalar types directly and any other value
+// using the json encoding used for the records
+func encodeBinaryValue(t Type, val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	switch t {
+	case BOOL:
+		if val.(bool) {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case INT:
+		putVarint(&buf, int64(val.(int)))
+	case FLOAT:
+		binary.Write(&buf, binary.BigEndian, math.Float64bits(val.(float64)))
+	case STRING:
+		buf.WriteString(val.(string))
+	default:
+		return MarshalValue(val)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBinaryValue will decode the value as the type it was written with
+func decodeBinaryValue(t Type, b []byte) (interface{}, error) {
+	switch t {
+	case BOOL:
+		if len(b) != 1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return b[0] == 1, nil
+	case INT:
+		val, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return int(val), nil
+	case FLOAT:
+		if len(b) != 8 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case STRING:
+		return string(b), nil
+	}
+	return (&record{Type: t, Val: b}).value()
+}
+
+// putUvarint will write the unsigned varint to the buffer
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// putVarint will write the signed varint to the buffer
+func putVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+// teeByteReader will write every byte read to the writer so it can be
+// included in the checksum
+type teeByteReader struct {
+	r io.ByteReader
+	w io.Writer
+}
+
+// ReadByte implements io.ByteReader
+func (t *teeByteReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		t.w.Write([]byte{b})
+	}
+	return b, err
+}
@@ -0,0 +1,61 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinarySnapshotRoundTrip(t *testing.T) {
+	saved, loaded := roundTrip(t, BinaryFormat, typedValues)
+	for key, val := range typedValues {
+		got, err := loaded.GetValue(key)
+		if err != nil || !reflect.DeepEqual(got, val) {
+			t.Errorf("GetValue(%s) = %#v, %v, expected %#v", key, got, err, val)
+		}
+		if loaded.Version(key) != saved.Version(key) {
+			t.Errorf("Version(%s) = %d, expected %d", key, loaded.Version(key), saved.Version(key))
+		}
+	}
+	if ttl, err := loaded.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(ttl) = %s, %v, expected the time left", ttl, err)
+	}
+}
+
+func TestBinarySnapshotChecksum(t *testing.T) {
+	saved, _ := roundTrip(t, BinaryFormat, typedValues)
+	b, err := os.ReadFile(saved.filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the payload of the first block after the header and the sequence
+	offset := len(binaryMagic) + 1
+	for i := 0; i < 3; i++ {
+		_, n := binary.Uvarint(b[offset:])
+		offset += n
+	}
+	for name, at := range map[string]int{"payload": offset, "checksum": len(b) - crc32.Size - 3} {
+		t.Run(name, func(t *testing.T) {
+			corrupt := append([]byte(nil), b...)
+			corrupt[at] ^= 0xff
+			if err := os.WriteFile(saved.filePath, corrupt, 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := NewEmptyStore()
+			s.SetValue("kept", true)
+			if err := s.loadSnapshot(saved.filePath); err == nil {
+				t.Fatal("The corrupt snapshot was loaded without an error")
+			}
+			if !s.KeyExists("kept") || s.KeyExists("int") {
+				t.Error("The store was changed by the corrupt snapshot")
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/landonia/keystore"
 	"github.com/landonia/keystore/transport"
 )
 
@@ -133,6 +134,17 @@ func main() {
 			log.Println(arbVal)
 		}
 
+		// Typed values can be read without asserting the type
+		hits := keystore.Key[int]("httphits")
+		if err := hits.Set(httpClient, 10); err != nil {
+			log.Println("An error occurred: ", err)
+		}
+		if val, err := hits.Get(httpClient); err != nil {
+			log.Println("An error occurred: ", err)
+		} else {
+			log.Println("The hits: ", val+1)
+		}
+
 		// Make another set value to delete the key after
 		if err := httpClient.SetString("httpkey2", "My httpkey2 value"); err != nil {
 			log.Println("An error occurred: ", err)
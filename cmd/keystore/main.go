@@ -4,9 +4,12 @@ package main
 
 import (
 	"flag"
+	"log"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/landonia/keystore"
 	"github.com/landonia/keystore/transport"
@@ -16,18 +19,82 @@ import (
 func main() {
 
 	// Define flags
-	var httpAddr, tcpAddr, udpAddr, dataPath string
+	var httpAddr, tcpAddr, udpAddr, dataPath, fsync, format, eviction string
+	var fsyncInterval, autosave time.Duration
+	var compactSize, maxMemory int64
+	var backups, autosaveWrites, maxKeys, shards int
 	flag.StringVar(&httpAddr, "httpAddr", ":8080", "the host:port to bind the HTTP server")
 	flag.StringVar(&tcpAddr, "tcpAddr", ":8081", "the host:port to bind the TCP server")
 	flag.StringVar(&udpAddr, "udpAddr", ":8082", "the host:port to bind the UDP server")
 	flag.StringVar(&dataPath, "dataPath", "", "the path to the file for saving the key store")
+	flag.StringVar(&fsync, "fsync", "interval", "when the write ahead log is flushed to disk: always, interval or never")
+	flag.DurationVar(&fsyncInterval, "fsyncInterval", keystore.DefaultSyncInterval, "how often the write ahead log is flushed using the interval policy")
+	flag.Int64Var(&compactSize, "compactSize", keystore.DefaultCompactionSize, "the size in bytes of the write ahead log before it is compacted")
+	flag.IntVar(&backups, "backups", keystore.DefaultBackupCount, "the number of previous snapshots to keep as backups")
+	flag.DurationVar(&autosave, "autosaveInterval", 0, "how often the key store is saved to disk if it has changed (0 disables)")
+	flag.IntVar(&autosaveWrites, "autosaveWrites", 0, "the number of writes after which the key store is saved to disk (0 disables)")
+	flag.StringVar(&format, "format", "json", "the file format used to save the key store: json or binary")
+	flag.IntVar(&maxKeys, "maxKeys", 0, "the maximum number of keys before keys are evicted (0 for no limit)")
+	flag.Int64Var(&maxMemory, "maxMemory", 0, "the maximum approximate size in bytes before keys are evicted (0 for no limit)")
+	flag.StringVar(&eviction, "eviction", "noeviction", "how keys are evicted once a limit is reached: noeviction, lru, lfu, volatile-ttl or random")
+	flag.IntVar(&shards, "shards", runtime.NumCPU(), "the number of shards the keys are divided between so that they can be used in parallel")
 	flag.Parse()
 
+	// Determine the policy used to flush the write ahead log
+	var policy keystore.SyncPolicy
+	switch fsync {
+	case "always":
+		policy = keystore.SyncAlways
+	case "interval":
+		policy = keystore.SyncInterval
+	case "never":
+		policy = keystore.SyncNever
+	default:
+		log.Fatalf("Unknown fsync policy: %s", fsync)
+	}
+
 	// The program will run until it receives the correct signal
 	done := GetSignalChannel()
 
+	// Determine the format used to save the key store
+	var fileFormat keystore.Format
+	switch format {
+	case "json":
+		fileFormat = keystore.JSONFormat
+	case "binary":
+		fileFormat = keystore.BinaryFormat
+	default:
+		log.Fatalf("Unknown file format: %s", format)
+	}
+
+	// Determine how keys are evicted once a limit is reached
+	var evictionPolicy keystore.EvictionPolicy
+	switch eviction {
+	case "noeviction":
+		evictionPolicy = keystore.NoEviction
+	case "lru":
+		evictionPolicy = keystore.EvictLRU
+	case "lfu":
+		evictionPolicy = keystore.EvictLFU
+	case "volatile-ttl":
+		evictionPolicy = keystore.EvictVolatileTTL
+	case "random":
+		evictionPolicy = keystore.EvictRandom
+	default:
+		log.Fatalf("Unknown eviction policy: %s", eviction)
+	}
+
 	// Create a key store in disk
 	ks := keystore.NewService(dataPath)
+	ks.UpdateSyncPolicy(policy, fsyncInterval)
+	ks.UpdateCompactionSize(compactSize)
+	ks.UpdateBackupCount(backups)
+	ks.UpdateAutosave(autosave, autosaveWrites)
+	ks.UpdateFormat(fileFormat)
+	ks.UpdateMaxKeys(maxKeys)
+	ks.UpdateMaxMemory(maxMemory)
+	ks.UpdateEvictionPolicy(evictionPolicy)
+	ks.UpdateShards(shards)
 
 	// Bind the network protocols that are required
 	transport.StartHTTPServer(httpAddr, ks.RequestChannel)
@@ -0,0 +1,157 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"math"
+)
+
+// NewIncrementRequest will generate a new Request for adding the delta to the
+// INT or FLOAT value of the key
+func NewIncrementRequest(key string, dType Type, delta interface{}) *Request {
+	return &Request{Op: INCR, Key: key, Value: &ValueHolder{Type: dType, Val: delta}, ResponseChannel: make(chan *Response)}
+}
+
+// NewDecrementRequest will generate a new Request for subtracting the delta from
+// the INT or FLOAT value of the key
+func NewDecrementRequest(key string, dType Type, delta interface{}) *Request {
+	return &Request{Op: DECR, Key: key, Value: &ValueHolder{Type: dType, Val: delta}, ResponseChannel: make(chan *Response)}
+}
+
+// IncrInt will add the delta to the INT value of the key and return the new
+// value. A key that does not exist is created with a value of zero first
+func (s *Store) IncrInt(key string, delta int) (int, error) {
+	var val int
+	if raw, exists := s.access(key); exists {
+		var ok bool
+		if val, ok = raw.(int); !ok {
+			return 0, generateTypeError(key)
+		}
+	}
+	val, ok := addInt(val, delta)
+	if !ok {
+		return 0, generateOverflowError(key, delta)
+	}
+	s.updateValue(key, val)
+	return val, nil
+}
+
+// IncrFloat will add the delta to the FLOAT value of the key and return the new
+// value. A key that does not exist is created with a value of zero first
+func (s *Store) IncrFloat(key string, delta float64) (float64, error) {
+	var val float64
+	if raw, exists := s.access(key); exists {
+		var ok bool
+		if val, ok = raw.(float64); !ok {
+			return 0, generateTypeError(key)
+		}
+	}
+	val += delta
+	s.updateValue(key, val)
+	return val, nil
+}
+
+// addInt returns the sum of the ints and false if the sum does not fit in an int
+func addInt(a, b int) (int, bool) {
+	sum := a + b
+	return sum, (b >= 0) == (sum >= a)
+}
+
+// generateOverflowError will return an error indicating that adding the delta
+// to the value of the key would take it beyond the range of an int
+func generateOverflowError(key string, delta int) error {
+	return generateError(Invalid, fmt.Sprintf("Adding %d to the value for key '%s' would overflow an int", delta, key))
+}
+
+// toInt returns the number as an int. Whole numbers that have been decoded
+// from json as a float64 are accepted if they are within the range of an int
+func toInt(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64
+	}
+	return 0, false
+}
+
+// toFloat returns the number as a float64
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// increment will add (or subtract for a DECR request) the delta to the value of
+// the key or the field of the map for a HINCR request. If the type is NONE it is
+// determined by the current value or by the delta if it does not exist
+func (sh *shard) increment(request *Request, response *Response) {
+	dType := request.Value.Type
+	if dType == NONE {
+		var current interface{}
+		var err error
+		if request.Op == HINCR {
+			current, err = sh.store.HGet(request.Key, request.Field)
+		} else {
+			current, err = sh.store.GetValue(request.Key)
+		}
+		if err == nil {
+			dType = TypeOf(current)
+		} else {
+			dType = TypeOf(request.Value.Val)
+		}
+	}
+
+	var err error
+	response.Value = &ValueHolder{Type: dType}
+	switch dType {
+	case INT:
+		delta, ok := toInt(request.Value.Val)
+		if !ok {
+			err = generateError(Invalid, fmt.Sprintf("The delta for key '%s' is not a whole number", request.Key))
+		} else if request.Op == DECR && delta == math.MinInt {
+			err = generateOverflowError(request.Key, delta)
+		} else {
+			if request.Op == DECR {
+				delta = -delta
+			}
+			if request.Op == HINCR {
+				response.Value.Val, err = sh.store.HIncrInt(request.Key, request.Field, delta)
+			} else {
+				response.Value.Val, err = sh.store.IncrInt(request.Key, delta)
+			}
+		}
+	case FLOAT:
+		delta, ok := toFloat(request.Value.Val)
+		if !ok {
+			err = generateError(Invalid, fmt.Sprintf("The delta for key '%s' is not a number", request.Key))
+		} else {
+			if request.Op == DECR {
+				delta = -delta
+			}
+			if request.Op == HINCR {
+				response.Value.Val, err = sh.store.HIncrFloat(request.Key, request.Field, delta)
+			} else {
+				response.Value.Val, err = sh.store.IncrFloat(request.Key, delta)
+			}
+		}
+	default:
+		err = generateTypeError(request.Key)
+	}
+
+	// Record the write so that it will survive a crash
+	if err == nil {
+		sh.changed(request.Key)
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.Value = nil
+		response.fail(err)
+	}
+}
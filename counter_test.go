@@ -0,0 +1,45 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestIncrementOverflow(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	ks.SetInt("max", math.MaxInt64-1)
+	ks.SetInt("min", math.MinInt64+1)
+	ks.SetMap("map", map[string]interface{}{"max": math.MaxInt64})
+
+	if val, err := ks.IncrInt("max", 1); err != nil || val != math.MaxInt64 {
+		t.Errorf("IncrInt(max, 1) = %d, %v, expected %d", val, err, int64(math.MaxInt64))
+	}
+	tests := map[string]func() (int, error){
+		"IncrInt":                 func() (int, error) { return ks.IncrInt("max", 1) },
+		"DecrInt":                 func() (int, error) { return ks.DecrInt("min", 2) },
+		"DecrInt of the min int":  func() (int, error) { return ks.DecrInt("zero", math.MinInt64) },
+		"IncrInt by a big number": func() (int, error) { return ks.IncrInt("min", math.MinInt64) },
+		"HIncrInt":                func() (int, error) { return ks.HIncrInt("map", "max", 1) },
+	}
+	for name, incr := range tests {
+		if val, err := incr(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s = %d, %v, expected the overflow to be invalid", name, val, err)
+		}
+	}
+
+	// The values are left as they were
+	for key, expected := range map[string]int{"max": math.MaxInt64, "min": math.MinInt64 + 1} {
+		if val, err := ks.GetInt(key); err != nil || val != expected {
+			t.Errorf("GetInt(%s) = %v, %v, expected %d", key, val, err, expected)
+		}
+	}
+	if _, err := ks.GetValue("zero"); !errors.Is(err, ErrNotFound) {
+		t.Error("The key was created by the increment that overflowed")
+	}
+}
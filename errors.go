@@ -0,0 +1,160 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorCode identifies the kind of error held by a response so that the
+// callers can handle an error without matching the message
+type ErrorCode uint
+
+// The codes for each kind of error
+const (
+	NoError      ErrorCode = iota // The request did not fail
+	UnknownError                  // The error could not be identified
+	NotFound                      // The key, field, member, path, watch or subscription does not exist
+	WrongType                     // The value is not of the type required by the operation
+	Conflict                      // The version or value of the key does not match the one expected
+	StoreFull                     // The store has reached its limits and no key could be evicted
+	Timeout                       // The deadline passed before the request could be answered
+	Canceled                      // The caller stopped waiting for the response
+	Unavailable                   // The service or the connection to it is not available
+	Invalid                       // The request is not valid
+)
+
+// codeNames are the names of each of the codes
+var codeNames = map[ErrorCode]string{
+	NoError:      "NoError",
+	UnknownError: "UnknownError",
+	NotFound:     "NotFound",
+	WrongType:    "WrongType",
+	Conflict:     "Conflict",
+	StoreFull:    "StoreFull",
+	Timeout:      "Timeout",
+	Canceled:     "Canceled",
+	Unavailable:  "Unavailable",
+	Invalid:      "Invalid",
+}
+
+// String returns the name of the code
+func (code ErrorCode) String() string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return codeNames[UnknownError]
+}
+
+// Error is returned for a failed request holding the code and message of the
+// error. Any Error matches the sentinel error for its code using errors.Is so
+// the message can describe the key without the callers having to match it
+type Error struct {
+	Code    ErrorCode // The kind of error
+	Message string    // The description of the error
+	cause   error     // The error that caused it, if any
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is returns true if the target is an Error with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Unwrap returns the error that caused the error, if any
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// The sentinel errors for each of the codes that can be compared using errors.Is
+var (
+	// ErrNotFound is returned when the key, or the part of its value, does not exist
+	ErrNotFound error = &Error{Code: NotFound, Message: "The key does not exist"}
+
+	// ErrWrongType is returned when the value is not of the type required
+	ErrWrongType error = &Error{Code: WrongType, Message: "The value is not of the correct type"}
+
+	// ErrVersionConflict is returned when a compare and swap write is made with a
+	// version that does not match the current version of the key
+	ErrVersionConflict error = &Error{Code: Conflict, Message: "The version does not match the current version of the key"}
+
+	// ErrStoreFull is returned when a write is rejected because the store has reached
+	// its maximum number of keys or memory and no key could be evicted
+	ErrStoreFull error = &Error{Code: StoreFull, Message: "The store is full"}
+
+	// ErrTimeout is returned when the deadline passes before the request is answered
+	ErrTimeout error = &Error{Code: Timeout, Message: "The request timed out"}
+
+	// ErrCanceled is returned when the caller stops waiting for the response
+	ErrCanceled error = &Error{Code: Canceled, Message: "The request was canceled"}
+
+	// ErrUnavailable is returned when the service or the connection to it is not available
+	ErrUnavailable error = &Error{Code: Unavailable, Message: "The service is not available"}
+
+	// ErrInvalid is returned when the request is not valid
+	ErrInvalid error = &Error{Code: Invalid, Message: "The request is not valid"}
+)
+
+// generateError will return a new error containing the message
+func generateError(code ErrorCode, message string) error {
+	return &Error{Code: code, Message: message}
+}
+
+// CodeOf returns the code of the error. The errors of a context are timeouts
+// or cancellations and any other error that is not an Error is unknown
+func CodeOf(err error) ErrorCode {
+	var e *Error
+	switch {
+	case err == nil:
+		return NoError
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	}
+	return UnknownError
+}
+
+// contextError returns the Error for the error of a context, which it wraps so
+// that it still matches the error of the context using errors.Is
+func contextError(err error) error {
+	return &Error{Code: CodeOf(err), Message: err.Error(), cause: err}
+}
+
+// fail will record the error in the response along with its code
+func (response *Response) fail(err error) {
+	response.Error = err.Error()
+	response.Code = CodeOf(err)
+}
+
+// Err returns the error held by the response or nil if there is no error. The
+// error is an Error with the code of the response so it matches the sentinel
+// errors using errors.Is, and a timeout or cancellation also matches the
+// error of a context
+func (response *Response) Err() error {
+	if response.Error == "" && response.Code == NoError {
+		return nil
+	}
+	e := &Error{Code: response.Code, Message: response.Error}
+	switch response.Code {
+	case NoError:
+		e.Code = UnknownError
+	case Timeout:
+		e.cause = context.DeadlineExceeded
+	case Canceled:
+		e.cause = context.Canceled
+	}
+	if e.Message == "" {
+		e.Message = e.Code.String()
+	}
+	return e
+}
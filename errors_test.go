@@ -0,0 +1,45 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestResponseErr(t *testing.T) {
+	tests := []struct {
+		response *Response
+		expected error
+	}{
+		{&Response{Error: "The version 3 does not match 4", Code: Conflict}, ErrVersionConflict},
+		{&Response{Error: "No key could be evicted", Code: StoreFull}, ErrStoreFull},
+		{&Response{Error: "The pop timed out", Code: Timeout}, context.DeadlineExceeded},
+		{&Response{Error: "The client went away", Code: Canceled}, context.Canceled},
+		{&Response{Error: ErrSubscriptionClosed.Error(), Code: Unavailable}, ErrUnavailable},
+		{&Response{Code: Invalid}, ErrInvalid},
+	}
+	for _, test := range tests {
+		err := test.response.Err()
+		if !errors.Is(err, test.expected) {
+			t.Errorf("Err() = %v for %+v, expected it to match %v", err, test.response, test.expected)
+		}
+		if CodeOf(err) != test.response.Code {
+			t.Errorf("CodeOf(%v) = %s, expected %s", err, CodeOf(err), test.response.Code)
+		}
+	}
+
+	// The code decides the error rather than the message
+	err := (&Response{Error: ErrVersionConflict.Error(), Code: NotFound}).Err()
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Err() = %v (%s), expected the error of the code", err, CodeOf(err))
+	}
+	if err := (&Response{Error: "failed"}).Err(); CodeOf(err) != UnknownError {
+		t.Errorf("Err() without a code has the code %s, expected %s", CodeOf(err), UnknownError)
+	}
+	if err := (&Response{Success: true}).Err(); err != nil {
+		t.Errorf("Err() = %v for a successful response", err)
+	}
+}
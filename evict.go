@@ -0,0 +1,283 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// EvictionPolicy determines which keys are removed once the store has reached
+// the maximum number of keys or the maximum memory
+type EvictionPolicy uint
+
+// The policies available for evicting keys
+const (
+	NoEviction       EvictionPolicy = iota // Writes are rejected with ErrStoreFull
+	EvictLRU                               // The least recently used key is evicted
+	EvictLFU                               // The least frequently used key is evicted
+	EvictVolatileTTL                       // The key with a TTL that expires soonest is evicted
+	EvictRandom                            // Any key is evicted
+)
+
+// EvictionSamples is the number of keys that are compared to choose the key
+// that is evicted. As with the eviction in redis only a sample of the keys is
+// used so the least recently or frequently used key is approximate
+const EvictionSamples = 5
+
+// keyOverhead is the approximate number of bytes used by the store for each key
+// in addition to the key and the value
+const keyOverhead = 96
+
+// growOperations are the operations that may add a key or grow a value and so
+// must have room made for them before they are applied
+const growOperations = WRITE | CAS | INCR | DECR | LPUSH | RPUSH | HSET | HINCR | PSET | PAPPEND | SADD | ZADD | ZINCRBY
+
+// keyUsage records how a key has been used so that it can be chosen for eviction
+type keyUsage struct {
+	accessed uint64 // The clock of the last access
+	hits     uint64 // The number of times the key has been accessed
+	size     int64  // The approximate size of the key and value
+}
+
+// Stats holds the size and limits of the store along with the number of keys
+// that have been evicted
+type Stats struct {
+	Keys       int            // The number of keys in the store
+	Memory     int64          // The approximate size of the store in bytes (only measured when there is a max memory)
+	MaxKeys    int            // The maximum number of keys (zero for no limit)
+	MaxMemory  int64          // The maximum approximate size of the store in bytes (zero for no limit)
+	Policy     EvictionPolicy // How keys are removed once a limit has been reached
+	Evictions  uint64         // The number of keys that have been evicted
+	Rejections uint64         // The number of writes rejected because the store was full
+}
+
+// NewStatsRequest will generate a new Request for reading the stats of the store
+func NewStatsRequest() *Request {
+	return &Request{Op: STATS, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// UpdateMaxKeys will change the maximum number of keys in the store. Zero
+// removes the limit
+func (s *Store) UpdateMaxKeys(max int) {
+	s.maxKeys = max
+}
+
+// UpdateMaxMemory will change the maximum approximate size in bytes of the store.
+// The size of each value is measured as it is written, so this has a cost for
+// large values that are changed often. Zero removes the limit
+func (s *Store) UpdateMaxMemory(max int64) {
+	measure := s.maxMemory == 0 && max > 0
+	s.maxMemory = max
+	if measure {
+		s.resetUsage()
+	}
+}
+
+// UpdateEvictionPolicy will change how keys are removed once a limit has been reached
+func (s *Store) UpdateEvictionPolicy(policy EvictionPolicy) {
+	s.eviction = policy
+}
+
+// Stats returns the size and limits of the store
+func (s *Store) Stats() *Stats {
+	stats := &Stats{
+		MaxKeys:   s.maxKeys,
+		MaxMemory: s.maxMemory,
+		Policy:    s.eviction,
+	}
+	for _, part := range s.parts() {
+		stats.Keys += len(part.values)
+		stats.Memory += part.memory
+		stats.Evictions += part.evictions
+		stats.Rejections += part.rejections
+	}
+	return stats
+}
+
+// access returns the value of the key, deleting it first if it has expired,
+// and records the access so that the key is less likely to be evicted
+func (s *Store) access(key string) (interface{}, bool) {
+	s.expireKey(key)
+	val, exists := s.values[key]
+	if u, ok := s.usage[key]; ok && exists {
+		s.clock++
+		u.accessed = s.clock
+		u.hits++
+	}
+	return val, exists
+}
+
+// track will record the write of the value for the key as an access and
+// measure its size if there is a max memory
+func (s *Store) track(key string, value interface{}) {
+	u, ok := s.usage[key]
+	if !ok {
+		u = &keyUsage{}
+		s.usage[key] = u
+	}
+	s.clock++
+	u.accessed = s.clock
+	u.hits++
+	if s.maxMemory > 0 {
+		size := int64(len(key)) + keyOverhead + sizeOf(value)
+		s.memory += size - u.size
+		u.size = size
+	}
+}
+
+// forget will remove the usage of a key that has been deleted
+func (s *Store) forget(key string) {
+	if u, ok := s.usage[key]; ok {
+		s.memory -= u.size
+		delete(s.usage, key)
+	}
+}
+
+// resetUsage will start recording the usage of every key again. It is used once
+// the values have been replaced or when the size of each value must be measured
+func (s *Store) resetUsage() {
+	s.usage = make(map[string]*keyUsage, len(s.values))
+	s.memory = 0
+	for key, val := range s.values {
+		s.track(key, val)
+	}
+}
+
+// sizeOf returns the approximate number of bytes used by the value
+func sizeOf(val interface{}) int64 {
+	switch v := val.(type) {
+	case string:
+		return int64(len(v)) + 16
+	case []interface{}:
+		size := int64(24)
+		for _, item := range v {
+			size += sizeOf(item)
+		}
+		return size
+	case map[string]interface{}:
+		size := int64(48)
+		for field, item := range v {
+			size += int64(len(field)) + 16 + sizeOf(item)
+		}
+		return size
+	case Set:
+		size := int64(24)
+		for _, member := range v {
+			size += int64(len(member)) + 16
+		}
+		return size
+	case ZSet:
+		size := int64(24)
+		for _, m := range v {
+			size += int64(len(m.Member)) + 24
+		}
+		return size
+	}
+	return 16
+}
+
+// full returns true if writing the key would exceed the limits of the store
+func (s *Store) full(key string) bool {
+	if s.maxKeys > 0 && len(s.values) >= s.maxKeys {
+		if _, exists := s.values[key]; !exists || len(s.values) > s.maxKeys {
+			return true
+		}
+	}
+	return s.maxMemory > 0 && s.memory >= s.maxMemory
+}
+
+// makeRoom will evict keys until the key can be written without exceeding the
+// limits of the store and return the keys that were evicted. Any keys that must
+// be kept are never evicted. ErrStoreFull is returned if the policy does not
+// allow eviction or there are no keys left that can be evicted
+func (s *Store) makeRoom(key string, keep func(string) bool) ([]string, error) {
+	var evicted []string
+	for s.full(key) {
+		victim, ok := s.evict(keep)
+		if !ok {
+			s.rejections++
+			return evicted, ErrStoreFull
+		}
+		evicted = append(evicted, victim)
+	}
+	return evicted, nil
+}
+
+// evict will delete a key chosen by the eviction policy from a sample of the
+// keys. The key evicted is returned or false if there was no key to evict
+func (s *Store) evict(keep func(string) bool) (string, bool) {
+	var victim string
+	lowest := math.Inf(1)
+	samples := 0
+
+	// Each key in the sample is scored and the lowest score is evicted
+	sample := func(key string) bool {
+		if keep(key) {
+			return true
+		}
+		var score float64
+		switch s.eviction {
+		case EvictLRU:
+			score = float64(s.usage[key].accessed)
+		case EvictLFU:
+			score = float64(s.usage[key].hits)
+		case EvictVolatileTTL:
+			score = float64(s.expires[key].UnixNano())
+		}
+		if score < lowest {
+			victim, lowest = key, score
+		}
+		samples++
+		return samples < EvictionSamples
+	}
+
+	// The order that a map is ranged over is random so the first keys are used
+	// as the sample. Only the keys with a TTL are used by the volatile policy
+	switch s.eviction {
+	case NoEviction:
+		return "", false
+	case EvictVolatileTTL:
+		for key := range s.expires {
+			if !sample(key) {
+				break
+			}
+		}
+	default:
+		for key := range s.usage {
+			if !sample(key) {
+				break
+			}
+		}
+	}
+	if samples == 0 {
+		return "", false
+	}
+	s.DeleteKey(victim)
+	s.evictions++
+	return victim, true
+}
+
+// makeRoom will evict keys of the shard, if required, so that the key of the
+// request can be written. The keys changed by a transaction being applied are
+// kept as they may need to be restored. Any evictions are logged immediately as
+// they are never undone
+func (sh *shard) makeRoom(key string) error {
+	evicted, err := sh.store.makeRoom(key, func(k string) bool {
+		_, pending := sh.pending[k]
+		return k == key || pending
+	})
+	if len(evicted) > 0 {
+		sh.store.logChanges(evicted...)
+		atomic.AddInt64(&sh.service.writes, int64(len(evicted)))
+		sh.service.notify(evicted...)
+	}
+	return err
+}
+
+// stats will return the size and limits of the store
+func (ks *Service) stats(response *Response) {
+	response.Stats = ks.store.Stats()
+	response.Success = true
+}
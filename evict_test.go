@@ -0,0 +1,136 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// startLimitedService will start a service with a single shard that holds up
+// to three keys, evicting them using the policy
+func startLimitedService(policy EvictionPolicy) *Service {
+	ks := NewService("")
+	ks.UpdateShards(1)
+	ks.UpdateMaxKeys(3)
+	ks.UpdateEvictionPolicy(policy)
+	ks.Start()
+	return ks
+}
+
+func TestFullStoreRejectsWrites(t *testing.T) {
+	ks := startLimitedService(NoEviction)
+	defer func() { <-ks.Stop() }()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := ks.SetInt(key, 1); err != nil {
+			t.Fatalf("SetInt(%s): %s", key, err)
+		}
+	}
+
+	// The response of the rejected write holds the code and the key is not written
+	response, err := ks.SendAsync(NewWriteRequest("d", INT, 1)).Wait()
+	if !errors.Is(err, ErrStoreFull) || response.Success || response.Code != StoreFull {
+		t.Errorf("The write to a full store returned %+v, %v, expected it to be rejected", response, err)
+	}
+	if _, err = ks.RPush("list", 1); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("RPush to a full store returned %v, expected it to be rejected", err)
+	}
+	if _, err = ks.GetValue("d"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue(d) returned %v, expected the rejected write not to be applied", err)
+	}
+
+	// The keys already held can still be changed
+	if err = ks.SetInt("a", 2); err != nil {
+		t.Errorf("SetInt of an existing key returned %s", err)
+	}
+	if stats, err := ks.Stats(); err != nil || stats.Keys != 3 || stats.Rejections != 2 || stats.Evictions != 0 {
+		t.Errorf("Stats = %+v, %v, expected 3 keys and 2 rejections", stats, err)
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	tests := map[EvictionPolicy]struct {
+		prepare func(ks *Service)
+		evicted string
+	}{
+		EvictLRU: {
+			prepare: func(ks *Service) {
+				ks.GetValue("a")
+				ks.GetValue("c")
+			},
+			evicted: "b",
+		},
+		EvictLFU: {
+			prepare: func(ks *Service) {
+				for i := 0; i < 3; i++ {
+					ks.GetValue("a")
+					ks.GetValue("b")
+				}
+				ks.GetValue("c")
+			},
+			evicted: "c",
+		},
+		EvictVolatileTTL: {
+			prepare: func(ks *Service) {
+				ks.SetIntWithTTL("b", 1, time.Hour)
+				ks.SetIntWithTTL("c", 1, time.Minute)
+			},
+			evicted: "c",
+		},
+	}
+	for policy, test := range tests {
+		ks := startLimitedService(policy)
+		for _, key := range []string{"a", "b", "c"} {
+			ks.SetInt(key, 1)
+		}
+		test.prepare(ks)
+		if err := ks.SetInt("d", 1); err != nil {
+			t.Errorf("Policy %d: SetInt(d) returned %s, expected a key to be evicted", policy, err)
+		}
+		for _, key := range []string{"a", "b", "c", "d"} {
+			_, err := ks.GetValue(key)
+			if evicted := errors.Is(err, ErrNotFound); evicted != (key == test.evicted) {
+				t.Errorf("Policy %d: GetValue(%s) returned %v, expected %s to be evicted", policy, key, err, test.evicted)
+			}
+		}
+		if stats, err := ks.Stats(); err != nil || stats.Keys != 3 || stats.Evictions != 1 {
+			t.Errorf("Policy %d: Stats = %+v, %v, expected 3 keys and 1 eviction", policy, stats, err)
+		}
+		<-ks.Stop()
+	}
+}
+
+func TestVolatileEvictionKeepsKeysWithoutTTL(t *testing.T) {
+	ks := startLimitedService(EvictVolatileTTL)
+	defer func() { <-ks.Stop() }()
+	for _, key := range []string{"a", "b", "c"} {
+		ks.SetInt(key, 1)
+	}
+	if err := ks.SetInt("d", 1); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("SetInt(d) returned %v, expected it to be rejected as no key has a TTL", err)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(1)
+	ks.UpdateMaxMemory(1024)
+	ks.UpdateEvictionPolicy(EvictLRU)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	value := string(make([]byte, 200))
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err := ks.SetString(key, value); err != nil {
+			t.Fatalf("SetString(%s): %s", key, err)
+		}
+	}
+	stats, err := ks.Stats()
+	if err != nil || stats.Evictions == 0 || stats.Memory > stats.MaxMemory+int64(len(value))+keyOverhead+16 {
+		t.Errorf("Stats = %+v, %v, expected keys to be evicted to stay near the limit", stats, err)
+	}
+	if _, err = ks.GetValue("h"); err != nil {
+		t.Errorf("GetValue(h) returned %v, expected the newest key to be kept", err)
+	}
+}
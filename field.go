@@ -0,0 +1,207 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The fields belong to MAP values. A map is never changed in place as it may have
+// been returned in a response that is still being read, instead the map is copied
+// with the change applied
+
+// NewFieldReadRequest will generate a new Request for reading a field of the map
+func NewFieldReadRequest(key, field string) *Request {
+	return &Request{Op: HGET, Key: key, Field: field, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewFieldWriteRequest will generate a new Request for writing a field of the map
+func NewFieldWriteRequest(key, field string, value interface{}) *Request {
+	return &Request{Op: HSET, Key: key, Field: field, Value: &ValueHolder{Type: NONE, Val: value}, ResponseChannel: make(chan *Response)}
+}
+
+// NewFieldDeleteRequest will generate a new Request for deleting a field of the map
+func NewFieldDeleteRequest(key, field string) *Request {
+	return &Request{Op: HDEL, Key: key, Field: field, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewFieldsRequest will generate a new Request for listing the fields of the map
+func NewFieldsRequest(key string) *Request {
+	return &Request{Op: HKEYS, Key: key, Value: &ValueHolder{Type: MAP}, ResponseChannel: make(chan *Response)}
+}
+
+// NewFieldExistsRequest will generate a new Request for checking a field of the map exists
+func NewFieldExistsRequest(key, field string) *Request {
+	return &Request{Op: HEXISTS, Key: key, Field: field, Value: &ValueHolder{Type: BOOL}, ResponseChannel: make(chan *Response)}
+}
+
+// NewFieldIncrementRequest will generate a new Request for adding the delta to the
+// INT or FLOAT value of a field of the map
+func NewFieldIncrementRequest(key, field string, dType Type, delta interface{}) *Request {
+	return &Request{Op: HINCR, Key: key, Field: field, Value: &ValueHolder{Type: dType, Val: delta}, ResponseChannel: make(chan *Response)}
+}
+
+// getFields returns the map for the key. A key that does not exist is an empty map
+func (s *Store) getFields(key string) (map[string]interface{}, error) {
+	raw, exists := s.access(key)
+	if !exists {
+		return nil, nil
+	}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, generateTypeError(key)
+	}
+	return fields, nil
+}
+
+// updateField will store a copy of the map with the field set to the value. The
+// field is deleted if remove is true. A key that does not exist is created
+func (s *Store) updateField(key, field string, value interface{}, remove bool) error {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return err
+	}
+	updated := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		updated[k] = v
+	}
+	if remove {
+		delete(updated, field)
+	} else if updated[field], err = normalizeItem(value); err != nil {
+		return err
+	}
+	s.updateValue(key, updated)
+	return nil
+}
+
+// HGet returns the value of the field of the map
+func (s *Store) HGet(key, field string) (interface{}, error) {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return nil, err
+	}
+	val, exists := fields[field]
+	if !exists {
+		return nil, generateError(NotFound, fmt.Sprintf("The field '%s' does not exist for key '%s'", field, key))
+	}
+	return val, nil
+}
+
+// HSet will store the value for the field of the map. A key that does not
+// exist is created
+func (s *Store) HSet(key, field string, value interface{}) error {
+	return s.updateField(key, field, value, false)
+}
+
+// HDel will delete the field of the map returning whether it existed
+func (s *Store) HDel(key, field string) (bool, error) {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return false, err
+	}
+	if _, exists := fields[field]; !exists {
+		return false, nil
+	}
+	return true, s.updateField(key, field, nil, true)
+}
+
+// HKeys returns the fields of the map in order
+func (s *Store) HKeys(key string) ([]string, error) {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(fields))
+	for field := range fields {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// HExists returns whether the field of the map exists
+func (s *Store) HExists(key, field string) (bool, error) {
+	fields, err := s.getFields(key)
+	_, exists := fields[field]
+	return exists, err
+}
+
+// HIncrInt will add the delta to the INT value of the field and return the new
+// value. A field that does not exist is created with a value of zero first
+func (s *Store) HIncrInt(key, field string, delta int) (int, error) {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return 0, err
+	}
+	var val int
+	if raw, exists := fields[field]; exists {
+		var ok bool
+		if val, ok = raw.(int); !ok {
+			return 0, generateFieldTypeError(key, field)
+		}
+	}
+	val, ok := addInt(val, delta)
+	if !ok {
+		return 0, generateOverflowError(key, delta)
+	}
+	return val, s.updateField(key, field, val, false)
+}
+
+// HIncrFloat will add the delta to the FLOAT value of the field and return the new
+// value. A field that does not exist is created with a value of zero first
+func (s *Store) HIncrFloat(key, field string, delta float64) (float64, error) {
+	fields, err := s.getFields(key)
+	if err != nil {
+		return 0, err
+	}
+	var val float64
+	if raw, exists := fields[field]; exists {
+		var ok bool
+		if val, ok = raw.(float64); !ok {
+			return 0, generateFieldTypeError(key, field)
+		}
+	}
+	val += delta
+	return val, s.updateField(key, field, val, false)
+}
+
+// generateFieldTypeError will generate an error for a field of the wrong type
+func generateFieldTypeError(key, field string) error {
+	return generateError(WrongType, fmt.Sprintf("The field '%s' for key '%s' is not of the correct type", field, key))
+}
+
+// field will read, write or delete the field of the map
+func (sh *shard) field(request *Request, response *Response) {
+	var err error
+	switch request.Op {
+	case HGET:
+		var val interface{}
+		if val, err = sh.store.HGet(request.Key, request.Field); err == nil {
+			response.Value = &ValueHolder{Type: TypeOf(val), Val: val}
+		}
+	case HSET:
+		if err = sh.store.HSet(request.Key, request.Field, request.Value.Val); err == nil {
+			sh.changed(request.Key)
+		}
+	case HDEL:
+		var deleted bool
+		if deleted, err = sh.store.HDel(request.Key, request.Field); deleted {
+			sh.changed(request.Key)
+		}
+	case HKEYS:
+		response.Keys, err = sh.store.HKeys(request.Key)
+	case HEXISTS:
+		var exists bool
+		if exists, err = sh.store.HExists(request.Key, request.Field); err == nil {
+			response.Value = &ValueHolder{Type: BOOL, Val: exists}
+		}
+	}
+	if err == nil {
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
@@ -0,0 +1,131 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"context"
+	"sync"
+)
+
+// Future holds the result of an operation that is running in its own routine.
+// The result can be waited for or handled by a callback once it has arrived
+type Future[T any] struct {
+	done      chan struct{}    // Closed once the result has arrived
+	mutex     sync.Mutex       // Guards the callbacks
+	value     T                // The value returned by the operation
+	err       error            // The error returned by the operation
+	callbacks []func(T, error) // The callbacks waiting for the result
+}
+
+// Awaitable is implemented by every Future so that futures of different types
+// can be waited for together
+type Awaitable interface {
+	// Done returns a channel that is closed once the result has arrived
+	Done() <-chan struct{}
+
+	// Err will wait for the result and return its error
+	Err() error
+}
+
+// newFuture creates a future that is waiting for its result
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// Async will call the operation in its own routine and return a future for its
+// result. It can be used with any of the methods, such as the Context variants
+// to send the deadline of a context with the request. As each operation runs in
+// its own routine the operations are not applied in the order they were made,
+// for which the Async methods, such as GetIntAsync, must be used instead
+func Async[T any](operation func() (T, error)) *Future[T] {
+	f := newFuture[T]()
+	go func() {
+		f.complete(operation())
+	}()
+	return f
+}
+
+// complete will hold the result and call each of the callbacks with it
+func (f *Future[T]) complete(value T, err error) {
+	f.mutex.Lock()
+	f.value, f.err = value, err
+	close(f.done)
+	callbacks := f.callbacks
+	f.callbacks = nil
+	f.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(value, err)
+	}
+}
+
+// Done returns a channel that is closed once the result has arrived
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait will block until the result has arrived and return it
+func (f *Future[T]) Wait() (T, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// WaitContext will block until the result has arrived or the context is done.
+// The operation is not stopped once the context is done and its result can
+// still be waited for again
+func (f *Future[T]) WaitContext(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var t T
+		return t, contextError(ctx.Err())
+	}
+}
+
+// Err will block until the result has arrived and return its error
+func (f *Future[T]) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Then will register the callback to be called with the result once it has
+// arrived. The callbacks are called in the order they were registered by the
+// routine that ran the operation, or immediately if the result has already
+// arrived, so they should not block for long
+func (f *Future[T]) Then(callback func(T, error)) {
+	f.mutex.Lock()
+	select {
+	case <-f.done:
+		f.mutex.Unlock()
+		callback(f.value, f.err)
+	default:
+		f.callbacks = append(f.callbacks, callback)
+		f.mutex.Unlock()
+	}
+}
+
+// WaitAll will block until the result of every future has arrived. The first
+// error of the futures, in the order they are given, is returned
+func WaitAll(futures ...Awaitable) error {
+	return WaitAllContext(context.Background(), futures...)
+}
+
+// WaitAllContext will block until the result of every future has arrived or the
+// context is done. The first error of the futures, in the order they are given,
+// is returned
+func WaitAllContext(ctx context.Context, futures ...Awaitable) error {
+	for _, f := range futures {
+		select {
+		case <-f.Done():
+		case <-ctx.Done():
+			return contextError(ctx.Err())
+		}
+	}
+	for _, f := range futures {
+		if err := f.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultScanLimit is the number of keys returned by a scan if no limit is given
+const DefaultScanLimit = 100
+
+// ScanOptions determines which of the keys are returned by a scan. The keys are
+// always returned in order
+type ScanOptions struct {
+	Prefix string // Only keys beginning with the prefix are returned
+	Start  string // Only keys that are equal to or after start are returned
+	End    string // Only keys before end are returned (empty for no end)
+	Limit  int    // The maximum number of keys returned (DefaultScanLimit if zero)
+	Cursor string // The cursor returned by the previous page of results
+}
+
+// keyIndex holds the keys of the store in order so they can be scanned
+type keyIndex struct {
+	keys []string
+}
+
+// newKeyIndex creates the index from the keys of the values
+func newKeyIndex(values map[string]interface{}) *keyIndex {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &keyIndex{keys: keys}
+}
+
+// seek returns the position of the first key that is equal to or after the key
+func (ix *keyIndex) seek(key string) int {
+	return sort.SearchStrings(ix.keys, key)
+}
+
+// insert will add the key to the index if it is not already present
+func (ix *keyIndex) insert(key string) {
+	i := ix.seek(key)
+	if i < len(ix.keys) && ix.keys[i] == key {
+		return
+	}
+	ix.keys = append(ix.keys, "")
+	copy(ix.keys[i+1:], ix.keys[i:])
+	ix.keys[i] = key
+}
+
+// remove will delete the key from the index if it is present
+func (ix *keyIndex) remove(key string) {
+	i := ix.seek(key)
+	if i < len(ix.keys) && ix.keys[i] == key {
+		ix.keys = append(ix.keys[:i], ix.keys[i+1:]...)
+	}
+}
+
+// Scan will return the keys in order that match the options along with the
+// cursor to request the next page. The cursor is empty once there are no more keys
+func (s *Store) Scan(options ScanOptions) (keys []string, cursor string) {
+	limit := options.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+	if len(s.shards) == 0 {
+		return s.scan(options, limit)
+	}
+
+	// Each shard returns up to the limit of its own keys which are merged in order
+	var more bool
+	for _, part := range s.shards {
+		partKeys, partCursor := part.scan(options, limit)
+		keys = append(keys, partKeys...)
+		more = more || partCursor != ""
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys, more = keys[:limit], true
+	}
+	if more {
+		cursor = keys[len(keys)-1]
+	}
+	return
+}
+
+// scan will return up to the limit of the keys in order that match the options
+// along with the cursor to request the next page
+func (s *Store) scan(options ScanOptions, limit int) (keys []string, cursor string) {
+
+	// Find the first key that could match
+	from := options.Start
+	if options.Prefix > from {
+		from = options.Prefix
+	}
+	i := s.index.seek(from)
+	if options.Cursor != "" && options.Cursor >= from {
+		i = s.index.seek(options.Cursor)
+		if i < len(s.index.keys) && s.index.keys[i] == options.Cursor {
+			i++
+		}
+	}
+
+	// Collect the keys until the end of the range has been reached
+	now := time.Now()
+	for ; i < len(s.index.keys); i++ {
+		key := s.index.keys[i]
+		if !strings.HasPrefix(key, options.Prefix) || (options.End != "" && key >= options.End) {
+			break
+		}
+		if expires, ok := s.expires[key]; ok && !now.Before(expires) {
+			continue
+		}
+		if len(keys) == limit {
+			cursor = keys[len(keys)-1]
+			break
+		}
+		keys = append(keys, key)
+	}
+	return
+}
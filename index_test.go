@@ -0,0 +1,58 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestScanPagesAcrossShards(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(4)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	var expected []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("user.%02d", i)
+		expected = append(expected, key)
+		if err := ks.SetValue(key, i); err != nil {
+			t.Fatalf("SetValue(%s): %s", key, err)
+		}
+		ks.SetValue(fmt.Sprintf("other.%02d", i), i)
+	}
+	sort.Strings(expected)
+
+	// Every page holds the next keys in order whichever shard holds them
+	var scanned []string
+	options := ScanOptions{Prefix: "user.", Limit: 7}
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatal("Scan did not end")
+		}
+		keys, cursor, err := ks.Scan(options)
+		if err != nil {
+			t.Fatalf("Scan: %s", err)
+		}
+		if len(keys) > options.Limit {
+			t.Fatalf("Scan returned %d keys, expected no more than %d", len(keys), options.Limit)
+		}
+		scanned = append(scanned, keys...)
+		if cursor == "" {
+			break
+		}
+		options.Cursor = cursor
+	}
+	if !reflect.DeepEqual(scanned, expected) {
+		t.Errorf("Scan returned %v, expected %v", scanned, expected)
+	}
+
+	// A range is inclusive of the start and exclusive of the end
+	keys, _, err := ks.Scan(ScanOptions{Start: "user.10", End: "user.13"})
+	if err != nil || !reflect.DeepEqual(keys, []string{"user.10", "user.11", "user.12"}) {
+		t.Errorf("Scan of a range returned %v, %v", keys, err)
+	}
+}
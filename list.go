@@ -0,0 +1,366 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"time"
+)
+
+// The lists are ARRAY values. The items of a list are never changed in place as
+// the slice may have been returned in a response that is still being read. Items
+// are only appended beyond the length of the slice (which a reader cannot see) and
+// the capacity is limited when the list shrinks so that the next push will copy it
+
+// waiter is a blocking pop request that is waiting for an item to be pushed
+type waiter struct {
+	request *Request      // The pop request
+	timer   *time.Timer   // The timer that will end the wait
+	ended   chan struct{} // Closed once the wait has ended
+}
+
+// NewPushRequest will generate a new Request for adding the items to the start
+// (LPUSH) or end (RPUSH) of the list
+func NewPushRequest(op Op, key string, items ...interface{}) *Request {
+	return &Request{Op: op, Key: key, Value: &ValueHolder{Type: ARRAY, Val: items}, ResponseChannel: make(chan *Response)}
+}
+
+// NewPopRequest will generate a new Request for removing the first (LPOP) or last
+// (RPOP) item of the list. If the timeout is greater than zero the request will
+// wait for an item to be pushed if the list is empty
+func NewPopRequest(op Op, key string, timeout time.Duration) *Request {
+	return &Request{Op: op, Key: key, Value: &ValueHolder{Type: NONE}, Timeout: timeout, ResponseChannel: make(chan *Response)}
+}
+
+// NewRangeRequest will generate a new Request for reading the items from start
+// to stop (inclusive). Negative indexes are counted from the end of the list
+func NewRangeRequest(key string, start, stop int) *Request {
+	return &Request{Op: LRANGE, Key: key, Value: &ValueHolder{Type: ARRAY}, Start: start, Stop: stop, ResponseChannel: make(chan *Response)}
+}
+
+// NewTrimRequest will generate a new Request for removing all of the items
+// except those from start to stop (inclusive)
+func NewTrimRequest(key string, start, stop int) *Request {
+	return &Request{Op: LTRIM, Key: key, Value: &ValueHolder{Type: ARRAY}, Start: start, Stop: stop, ResponseChannel: make(chan *Response)}
+}
+
+// NewLengthRequest will generate a new Request for reading the length of the list
+func NewLengthRequest(key string) *Request {
+	return &Request{Op: LLEN, Key: key, Value: &ValueHolder{Type: INT}, ResponseChannel: make(chan *Response)}
+}
+
+// getList returns the list for the key. A key that does not exist is an empty list
+func (s *Store) getList(key string) ([]interface{}, error) {
+	raw, exists := s.access(key)
+	if !exists {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, generateTypeError(key)
+	}
+	return list, nil
+}
+
+// LPush will add the items to the start of the list so that the last item is
+// first and return the new length. A key that does not exist is created
+func (s *Store) LPush(key string, items ...interface{}) (int, error) {
+	list, err := s.getList(key)
+	if err != nil {
+		return 0, err
+	}
+	if items, err = normalizeItems(items); err != nil {
+		return 0, err
+	}
+	pushed := make([]interface{}, 0, len(items)+len(list))
+	for i := len(items) - 1; i >= 0; i-- {
+		pushed = append(pushed, items[i])
+	}
+	pushed = append(pushed, list...)
+	s.updateValue(key, pushed)
+	return len(pushed), nil
+}
+
+// RPush will add the items to the end of the list and return the new length.
+// A key that does not exist is created
+func (s *Store) RPush(key string, items ...interface{}) (int, error) {
+	list, err := s.getList(key)
+	if err != nil {
+		return 0, err
+	}
+	if items, err = normalizeItems(items); err != nil {
+		return 0, err
+	}
+	if list == nil {
+		list = make([]interface{}, 0, len(items))
+	}
+	list = append(list, items...)
+	s.updateValue(key, list)
+	return len(list), nil
+}
+
+// LPop will remove and return the first item of the list. The key is deleted
+// once the last item has been removed
+func (s *Store) LPop(key string) (interface{}, error) {
+	list, err := s.getList(key)
+	if err != nil {
+		return nil, err
+	} else if len(list) == 0 {
+		return nil, generateError(NotFound, fmt.Sprintf("The list for key '%s' is empty", key))
+	}
+	s.updateList(key, list[1:])
+	return list[0], nil
+}
+
+// RPop will remove and return the last item of the list. The key is deleted
+// once the last item has been removed
+func (s *Store) RPop(key string) (interface{}, error) {
+	list, err := s.getList(key)
+	if err != nil {
+		return nil, err
+	} else if len(list) == 0 {
+		return nil, generateError(NotFound, fmt.Sprintf("The list for key '%s' is empty", key))
+	}
+	last := len(list) - 1
+	s.updateList(key, list[:last:last])
+	return list[last], nil
+}
+
+// LRange returns the items from start to stop (inclusive). Negative indexes are
+// counted from the end of the list so 0 to -1 returns every item
+func (s *Store) LRange(key string, start, stop int) ([]interface{}, error) {
+	list, err := s.getList(key)
+	if err != nil {
+		return nil, err
+	}
+	start, stop = listRange(len(list), start, stop)
+	return list[start:stop:stop], nil
+}
+
+// LTrim will remove all of the items except those from start to stop (inclusive)
+func (s *Store) LTrim(key string, start, stop int) error {
+	list, err := s.getList(key)
+	if err != nil || list == nil {
+		return err
+	}
+	start, stop = listRange(len(list), start, stop)
+	s.updateList(key, list[start:stop:stop])
+	return nil
+}
+
+// updateList will replace the list for the key. A list left empty is deleted
+// so that the key no longer exists, just as it did before the first push
+func (s *Store) updateList(key string, list []interface{}) {
+	if len(list) == 0 {
+		s.DeleteKey(key)
+	} else {
+		s.updateValue(key, list)
+	}
+}
+
+// LLen returns the length of the list
+func (s *Store) LLen(key string) (int, error) {
+	list, err := s.getList(key)
+	return len(list), err
+}
+
+// listRange converts the inclusive indexes (which may be negative) into the
+// bounds of a slice of the list
+func listRange(length, start, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// push will add the items in the value of the request to the list
+func (sh *shard) push(request *Request, response *Response) {
+	items, ok := request.Value.Val.([]interface{})
+	if !ok {
+		items = []interface{}{request.Value.Val}
+	}
+	var length int
+	var err error
+	if request.Op == LPUSH {
+		length, err = sh.store.LPush(request.Key, items...)
+	} else {
+		length, err = sh.store.RPush(request.Key, items...)
+	}
+	if err == nil {
+		sh.changed(request.Key)
+		response.Value = &ValueHolder{Type: INT, Val: length}
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
+
+// pop will remove an item from the list. If the list is empty and the request
+// has a timeout the request will wait until an item is pushed or the timeout ends
+func (sh *shard) pop(request *Request, response *Response) {
+	if length, err := sh.store.LLen(request.Key); err == nil && length == 0 && request.Timeout > 0 && sh.pending == nil {
+		sh.wait(request)
+		return
+	}
+	var val interface{}
+	var err error
+	if request.Op == LPOP {
+		val, err = sh.store.LPop(request.Key)
+	} else {
+		val, err = sh.store.RPop(request.Key)
+	}
+	if err == nil {
+		sh.changed(request.Key)
+		response.Value = &ValueHolder{Type: TypeOf(val), Val: val}
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
+
+// rangeList will read the items or trim the list for the range of the request
+func (sh *shard) rangeList(request *Request, response *Response) {
+	var err error
+	if request.Op == LRANGE {
+		var items []interface{}
+		if items, err = sh.store.LRange(request.Key, request.Start, request.Stop); err == nil {
+			response.Value = &ValueHolder{Type: ARRAY, Val: items}
+			response.Version = sh.store.Version(request.Key)
+		}
+	} else if err = sh.store.LTrim(request.Key, request.Start, request.Stop); err == nil {
+		sh.changed(request.Key)
+		response.Version = sh.store.Version(request.Key)
+	}
+	if err == nil {
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
+
+// listLength will return the length of the list
+func (sh *shard) listLength(request *Request, response *Response) {
+	length, err := sh.store.LLen(request.Key)
+	if err == nil {
+		response.Value = &ValueHolder{Type: INT, Val: length}
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
+
+// wait will hold the pop request until an item is pushed to the list or the
+// timeout ends. The response will be sent once the wait has finished
+func (sh *shard) wait(request *Request) {
+	w := &waiter{request: request, ended: make(chan struct{})}
+	timeout := request.Timeout
+	if !request.Deadline.IsZero() && time.Until(request.Deadline) < timeout {
+		timeout = time.Until(request.Deadline)
+	}
+	w.timer = time.AfterFunc(timeout, func() {
+		select {
+		case sh.timeouts <- w:
+		case <-sh.stopped:
+		}
+	})
+	sh.waiters[request.Key] = append(sh.waiters[request.Key], w)
+	sh.parked = true
+
+	// The wait also ends as soon as the caller stops waiting, such as once the
+	// connection that the request was made on has closed
+	if request.Done != nil {
+		go func() {
+			select {
+			case <-request.Done:
+				select {
+				case sh.timeouts <- w:
+				case <-sh.stopped:
+				}
+			case <-w.ended:
+			}
+		}()
+	}
+}
+
+// wakeWaiters will pop an item for each of the waiting requests in the order
+// they were made for any of the lists that have changed. Any request that the
+// caller has stopped waiting for is ended without an item being popped
+func (sh *shard) wakeWaiters() {
+	for key := range sh.ready {
+		delete(sh.ready, key)
+		for len(sh.waiters[key]) > 0 {
+			if length, err := sh.store.LLen(key); err != nil || length == 0 {
+				break
+			}
+			w := sh.waiters[key][0]
+			sh.removeWaiter(w)
+			w.timer.Stop()
+			if err := w.request.Err(); err != nil {
+				respond(w.request, &Response{Error: err.Error(), Code: CodeOf(err)})
+				continue
+			}
+			response := &Response{}
+			sh.pop(w.request, response)
+			respond(w.request, response)
+		}
+	}
+}
+
+// timeoutWaiter will end the wait for the request if it is still waiting, either
+// because the timeout has ended or the caller has stopped waiting
+func (sh *shard) timeoutWaiter(w *waiter) {
+	if sh.removeWaiter(w) {
+		w.timer.Stop()
+		response := &Response{Error: fmt.Sprintf("Timed out waiting for an item in the list for key '%s'", w.request.Key), Code: Timeout}
+		if err := w.request.Err(); err != nil {
+			response.fail(err)
+		}
+		respond(w.request, response)
+	}
+}
+
+// removeWaiter will remove the request from those waiting on the list
+func (sh *shard) removeWaiter(w *waiter) bool {
+	waiters := sh.waiters[w.request.Key]
+	for i := range waiters {
+		if waiters[i] == w {
+			waiters = append(waiters[:i:i], waiters[i+1:]...)
+			if len(waiters) == 0 {
+				delete(sh.waiters, w.request.Key)
+			} else {
+				sh.waiters[w.request.Key] = waiters
+			}
+			close(w.ended)
+			return true
+		}
+	}
+	return false
+}
+
+// stopWaiters will end the wait for every request as the service is stopping
+func (sh *shard) stopWaiters() {
+	for _, waiters := range sh.waiters {
+		for _, w := range waiters {
+			w.timer.Stop()
+			close(w.ended)
+			respond(w.request, &Response{Error: "The service has stopped", Code: Unavailable})
+		}
+	}
+	sh.waiters = make(map[string][]*waiter)
+}
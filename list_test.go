@@ -0,0 +1,94 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPopDeletesEmptyList(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	ks.RPush("list", "a", "b")
+	if val, err := ks.LPop("list"); err != nil || val != "a" {
+		t.Fatalf("LPop = %v, %v, expected a", val, err)
+	}
+	if val, err := ks.RPop("list"); err != nil || val != "b" {
+		t.Fatalf("RPop = %v, %v, expected b", val, err)
+	}
+
+	// The list no longer exists so the key can be written as another type
+	if _, err := ks.GetValue("list"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue returned %v once the list was empty, expected it to be deleted", err)
+	}
+	if keys, _, err := ks.Scan(ScanOptions{}); err != nil || len(keys) != 0 {
+		t.Errorf("Scan returned %v, %v, expected no keys", keys, err)
+	}
+	if err := ks.SetString("list", "value"); err != nil {
+		t.Errorf("SetString on the deleted list returned %s", err)
+	}
+
+	ks.RPush("trimmed", 1, 2)
+	if err := ks.LTrim("trimmed", 5, 10); err != nil {
+		t.Fatalf("LTrim: %s", err)
+	}
+	if _, err := ks.GetValue("trimmed"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue returned %v once the list was trimmed to nothing, expected it to be deleted", err)
+	}
+}
+
+func TestBlockingPopWokenByPush(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(4)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	type result struct {
+		val interface{}
+		err error
+	}
+	popped := make(chan result, 1)
+	go func() {
+		val, err := ks.BLPop("queue", 5*time.Second)
+		popped <- result{val, err}
+	}()
+
+	// A push to another key of the same shard does not wake the pop
+	var other string
+	for i := 0; other == ""; i++ {
+		if key := fmt.Sprintf("queue%d", i); shardOf(key, 4) == shardOf("queue", 4) {
+			other = key
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := ks.RPush(other, "other"); err != nil {
+		t.Fatalf("RPush(%s): %s", other, err)
+	}
+	select {
+	case r := <-popped:
+		t.Fatalf("BLPop returned %v, %v before the list was pushed to", r.val, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := ks.RPush("queue", "item"); err != nil {
+		t.Fatalf("RPush: %s", err)
+	}
+	select {
+	case r := <-popped:
+		if r.err != nil || r.val != "item" {
+			t.Fatalf("BLPop = %v, %v, expected item", r.val, r.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("BLPop was not woken by the push")
+	}
+	if length, err := ks.LLen(other); err != nil || length != 1 {
+		t.Errorf("LLen(%s) = %d, %v, expected the other list to be untouched", other, length, err)
+	}
+	if _, err := ks.GetValue("queue"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue returned %v once the pop emptied the list, expected it to be deleted", err)
+	}
+}
@@ -3,16 +3,96 @@
 // Package keystore provides an in memory key/value store service library
 package keystore
 
+import (
+	"context"
+	"encoding/gob"
+	"math"
+	"time"
+)
+
+// The ARRAY, MAP, SET and ZSET values are sent within the interface values of the
+// requests and responses so must be registered to be sent by the TCP and UDP transports
+func init() {
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+	gob.Register(Set{})
+	gob.Register(ZSet{})
+	gob.Register(ZMember{})
+}
+
 // Op is the operation type for the request to the data store
-type Op uint
+type Op uint64
 
 // Flags for the request operation type
 const (
 	READ   Op = 1 << iota // A request to read the value
 	WRITE  Op = 1 << iota // A request to write a value
 	DELETE Op = 1 << iota // A request to delete the key and value
+	FLUSH  Op = 1 << iota // A request to flush the store to disk
+	SCAN   Op = 1 << iota // A request to list the keys in order
+	CAS    Op = 1 << iota // A request to write a value only if the version matches
+
+	TRANSACTION Op = 1 << iota // A request to apply a list of steps atomically
+	COMPARE     Op = 1 << iota // A transaction step that checks the version or value of a key
+
+	MREAD   Op = 1 << iota // A request to read the values of many keys
+	MWRITE  Op = 1 << iota // A request to write the values of many keys
+	MDELETE Op = 1 << iota // A request to delete many keys
+
+	INCR Op = 1 << iota // A request to add to the value of a key
+	DECR Op = 1 << iota // A request to subtract from the value of a key
+
+	LPUSH  Op = 1 << iota // A request to add items to the start of a list
+	RPUSH  Op = 1 << iota // A request to add items to the end of a list
+	LPOP   Op = 1 << iota // A request to remove the first item of a list
+	RPOP   Op = 1 << iota // A request to remove the last item of a list
+	LRANGE Op = 1 << iota // A request to read a range of items from a list
+	LTRIM  Op = 1 << iota // A request to keep only a range of items in a list
+	LLEN   Op = 1 << iota // A request to read the length of a list
+
+	HGET    Op = 1 << iota // A request to read a field of a map
+	HSET    Op = 1 << iota // A request to write a field of a map
+	HDEL    Op = 1 << iota // A request to delete a field of a map
+	HKEYS   Op = 1 << iota // A request to list the fields of a map
+	HEXISTS Op = 1 << iota // A request to check a field of a map exists
+	HINCR   Op = 1 << iota // A request to add to the value of a field of a map
+
+	PGET    Op = 1 << iota // A request to read the value at a path within a map or array
+	PSET    Op = 1 << iota // A request to write the value at a path within a map or array
+	PDELETE Op = 1 << iota // A request to delete the value at a path within a map or array
+	PAPPEND Op = 1 << iota // A request to add items to the array at a path within a map or array
+
+	SADD      Op = 1 << iota // A request to add members to a set
+	SREM      Op = 1 << iota // A request to remove members from a set
+	SISMEMBER Op = 1 << iota // A request to check a member is within a set
+	SMEMBERS  Op = 1 << iota // A request to read the members of a set
+	SUNION    Op = 1 << iota // A request to read the union of many sets
+	SINTER    Op = 1 << iota // A request to read the intersection of many sets
+	SDIFF     Op = 1 << iota // A request to read the difference of many sets
+
+	ZADD          Op = 1 << iota // A request to add members to a sorted set
+	ZREM          Op = 1 << iota // A request to remove members from a sorted set
+	ZSCORE        Op = 1 << iota // A request to read the score of a member of a sorted set
+	ZRANK         Op = 1 << iota // A request to read the rank of a member of a sorted set
+	ZRANGE        Op = 1 << iota // A request to read the members of a sorted set by rank
+	ZRANGEBYSCORE Op = 1 << iota // A request to read the members of a sorted set by score
+	ZINCRBY       Op = 1 << iota // A request to add to the score of a member of a sorted set
+
+	STATS Op = 1 << iota // A request to read the size, limits and evictions of the store
+
+	WATCH   Op = 1 << iota // A request to receive an event for every change to the keys
+	UNWATCH Op = 1 << iota // A request to stop receiving the events of a watch
+
+	PUBLISH     Op = 1 << iota // A request to send a message to the subscribers of a topic
+	SUBSCRIBE   Op = 1 << iota // A request to receive the messages published to the topics
+	UNSUBSCRIBE Op = 1 << iota // A request to stop receiving the messages of a subscription
 )
 
+// AnyVersion can be used as the expected version of a compare and swap write so
+// that the value is only written if the key already exists. An expected version
+// of zero will only write the value if the key does not exist
+const AnyVersion uint64 = math.MaxUint64
+
 // Type allows the requester to specify the type of data it is expecting
 // This allows the caller to either handle the value or the error directly without
 // having to check the type. NONE can be specified meaning any type will be accepted
@@ -27,15 +107,53 @@ const (
 	ARRAY  Type = 1 << iota // Expecting an array
 	MAP    Type = 1 << iota // Expecting a map
 	NONE   Type = 1 << iota // Expecting any type
+	SET    Type = 1 << iota // Expecting a set
+	ZSET   Type = 1 << iota // Expecting a sorted set
 )
 
 // Request are the requests that will be sent over the channel
 // for operations on the store, such as a read or write
 type Request struct {
-	Op              Op             // The operation required
-	Key             string         // The key (used for all requests)
-	Value           *ValueHolder   // The request value (used for write requests only)
-	ResponseChannel chan *Response // The return channel
+	Op              Op              // The operation required
+	Key             string          // The key (used for all requests)
+	Field           string          // The field of the map (used for field requests only)
+	Path            string          // The path to the nested value e.g. user.addresses[0].city (used for path requests only)
+	Value           *ValueHolder    // The request value (used for write requests only)
+	Scan            *ScanOptions    // The keys to list (used for scan requests only)
+	Version         uint64          // The expected version of the key (used for compare and swap requests only)
+	Steps           []*Step         // The steps to apply (used for transaction requests only)
+	Keys            []string        // The keys (used for batch requests only)
+	Values          []*ValueHolder  // The values for each of the keys (used for batch write requests only)
+	Start           int             // The index of the first item (used for list range and trim requests only)
+	Stop            int             // The index of the last item (used for list range and trim requests only)
+	Timeout         time.Duration   // How long to wait for an item (used for list pop requests only)
+	Min             float64         // The lowest score (used for sorted set range requests only)
+	Max             float64         // The highest score (used for sorted set range requests only)
+	Watch           *WatchOptions   // The keys to watch (used for watch requests only)
+	Subscription    uint64          // The id of the watch or subscription (used for unwatch and unsubscribe requests only)
+	Events          chan *Event     // The channel that receives the events (used for watch requests only)
+	Topic           string          // The topic of the message (used for publish requests only)
+	Patterns        []string        // The topics or patterns (used for subscribe requests only)
+	Messages        chan *Message   // The channel that receives the messages (used for subscribe requests only)
+	Deadline        time.Time       // The request is dropped rather than applied once the deadline has passed (zero for no deadline)
+	Done            <-chan struct{} // Closed if the caller stops waiting for the response (never sent by the transports)
+	ID              uint64          // Matches the response to the request when many are sent on one connection (zero if not used)
+	ResponseChannel chan *Response  // The return channel
+}
+
+// Err returns context.DeadlineExceeded once the deadline of the request has
+// passed or context.Canceled if the caller has stopped waiting for the response
+// before then. Otherwise nil is returned and the request should still be applied
+func (request *Request) Err() error {
+	if !request.Deadline.IsZero() && !time.Now().Before(request.Deadline) {
+		return context.DeadlineExceeded
+	}
+	select {
+	case <-request.Done:
+		return context.Canceled
+	default:
+	}
+	return nil
 }
 
 // Response will be returned for each Request containing the result of the operation.
@@ -45,13 +163,25 @@ type Request struct {
 type Response struct {
 	Success bool         // True if the operation was a success (Error may still be present for write and delete operations)
 	Error   string       // Will contain any errors
+	Code    ErrorCode    // The kind of error (NoError if there is no error)
 	Value   *ValueHolder // The response values
+	Keys    []string     // The keys listed by a scan request
+	Cursor  string       // The cursor for the next page of a scan (empty if there are no more keys)
+	Version uint64       // The version of the key after the operation
+	Results []*Response  // The result of each step of a transaction or each key of a batch
+	Stats   *Stats       // The size, limits and evictions of the store (used for stats requests only)
+
+	ID           uint64   // The id of the request the response is for (zero for an event or message)
+	Subscription uint64   // The id of a new watch or subscription or of the one that the event or message is for
+	Event        *Event   // An event sent by a transport for a change to a watched key
+	Message      *Message // A message sent by a transport for a subscription
 }
 
 // ValueHolder wraps the value, but if no error the value will be of the type expected
 type ValueHolder struct {
-	Type Type        // The data type expected (if read Op)
-	Val  interface{} // The arbitrary value if NONE DataType specified
+	Type Type          // The data type expected (if read Op)
+	Val  interface{}   // The arbitrary value if NONE DataType specified
+	TTL  time.Duration // The time to live for a write (or the time remaining on a read). Zero never expires
 }
 
 // KeyValueStore Defines the interface for a simple key value store type.
@@ -89,6 +219,14 @@ type KeyValueStore interface {
 	// or the value is not of the correct type an error is returned
 	GetMap(key string) (interface{}, error)
 
+	// GetSet returns a set type for the key specified or if the key does not exist
+	// or the value is not of the correct type an error is returned
+	GetSet(key string) (interface{}, error)
+
+	// GetZSet returns a sorted set type for the key specified or if the key does not
+	// exist or the value is not of the correct type an error is returned
+	GetZSet(key string) (interface{}, error)
+
 	// SetValue will store an arbitrary type value for the key specified
 	SetValue(key string, value interface{}) error
 
@@ -110,10 +248,91 @@ type KeyValueStore interface {
 	// SetMap will store a map type value for the key specified
 	SetMap(key string, value interface{}) error
 
+	// SetSet will store a set type value for the key specified
+	SetSet(key string, value interface{}) error
+
+	// SetZSet will store a sorted set type value for the key specified
+	SetZSet(key string, value interface{}) error
+
+	// SetValueWithTTL will store an arbitrary type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetValueWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetBoolWithTTL will store an boolean type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetBoolWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetIntWithTTL will store an int type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetIntWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetFloatWithTTL will store a float type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetFloatWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetStringWithTTL will store a string type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetStringWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetArrayWithTTL will store an array type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetArrayWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetMapWithTTL will store a map type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetMapWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetSetWithTTL will store a set type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetSetWithTTL(key string, value interface{}, ttl time.Duration) error
+
+	// SetZSetWithTTL will store a sorted set type value for the key specified
+	// that will expire once the ttl has elapsed
+	SetZSetWithTTL(key string, value interface{}, ttl time.Duration) error
+
 	// DeleteKey will delete the key and value from the store
 	DeleteKey(key string)
 }
 
+// ContextKeyValueStore provides a variant of each of the KeyValueStore methods
+// that stops waiting once the context is done. Any deadline of the context is
+// sent with the request so that it is dropped rather than applied once the
+// deadline has passed
+type ContextKeyValueStore interface {
+	KeyValueStore
+	GetValueTypeContext(ctx context.Context, key string, v interface{}) error
+	GetValueContext(ctx context.Context, key string) (interface{}, error)
+	GetBoolContext(ctx context.Context, key string) (interface{}, error)
+	GetIntContext(ctx context.Context, key string) (interface{}, error)
+	GetFloatContext(ctx context.Context, key string) (interface{}, error)
+	GetStringContext(ctx context.Context, key string) (interface{}, error)
+	GetArrayContext(ctx context.Context, key string) (interface{}, error)
+	GetMapContext(ctx context.Context, key string) (interface{}, error)
+	GetSetContext(ctx context.Context, key string) (interface{}, error)
+	GetZSetContext(ctx context.Context, key string) (interface{}, error)
+	SetValueContext(ctx context.Context, key string, value interface{}) error
+	SetBoolContext(ctx context.Context, key string, value interface{}) error
+	SetIntContext(ctx context.Context, key string, value interface{}) error
+	SetFloatContext(ctx context.Context, key string, value interface{}) error
+	SetStringContext(ctx context.Context, key string, value interface{}) error
+	SetArrayContext(ctx context.Context, key string, value interface{}) error
+	SetMapContext(ctx context.Context, key string, value interface{}) error
+	SetSetContext(ctx context.Context, key string, value interface{}) error
+	SetZSetContext(ctx context.Context, key string, value interface{}) error
+	SetValueWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetBoolWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetIntWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetFloatWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetStringWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetArrayWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetMapWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetSetWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	SetZSetWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+
+	// DeleteKeyContext returns an error as the delete may not have been made
+	DeleteKeyContext(ctx context.Context, key string) error
+}
+
 // NewReadRequest will generate a new Request for reading a key
 func NewReadRequest(key string, dType Type) *Request {
 	return &Request{Op: READ, Key: key, Value: &ValueHolder{Type: dType}, ResponseChannel: make(chan *Response)}
@@ -124,7 +343,49 @@ func NewWriteRequest(key string, dType Type, value interface{}) *Request {
 	return &Request{Op: WRITE, Key: key, Value: &ValueHolder{Type: dType, Val: value}, ResponseChannel: make(chan *Response)}
 }
 
+// NewWriteRequestWithTTL will generate a new Request for writing a key that
+// will expire once the ttl has elapsed
+func NewWriteRequestWithTTL(key string, dType Type, value interface{}, ttl time.Duration) *Request {
+	return &Request{Op: WRITE, Key: key, Value: &ValueHolder{Type: dType, Val: value, TTL: ttl}, ResponseChannel: make(chan *Response)}
+}
+
 // NewDeleteRequest will generate a new Request for reading a key
 func NewDeleteRequest(key string) *Request {
 	return &Request{Op: DELETE, Key: key, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
 }
+
+// NewFlushRequest will generate a new Request for flushing the store to disk
+func NewFlushRequest() *Request {
+	return &Request{Op: FLUSH, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewScanRequest will generate a new Request for listing the keys in order
+func NewScanRequest(options ScanOptions) *Request {
+	return &Request{Op: SCAN, Value: &ValueHolder{Type: NONE}, Scan: &options, ResponseChannel: make(chan *Response)}
+}
+
+// NewCompareAndSwapRequest will generate a new Request for writing a key only if
+// its current version matches the version provided
+func NewCompareAndSwapRequest(key string, dType Type, value interface{}, version uint64) *Request {
+	return &Request{Op: CAS, Key: key, Value: &ValueHolder{Type: dType, Val: value}, Version: version, ResponseChannel: make(chan *Response)}
+}
+
+// NewBatchReadRequest will generate a new Request for reading many keys
+func NewBatchReadRequest(dType Type, keys ...string) *Request {
+	return &Request{Op: MREAD, Keys: keys, Value: &ValueHolder{Type: dType}, ResponseChannel: make(chan *Response)}
+}
+
+// NewBatchWriteRequest will generate a new Request for writing many keys
+func NewBatchWriteRequest(dType Type, values map[string]interface{}) *Request {
+	request := &Request{Op: MWRITE, Value: &ValueHolder{Type: dType}, ResponseChannel: make(chan *Response)}
+	for key, value := range values {
+		request.Keys = append(request.Keys, key)
+		request.Values = append(request.Values, &ValueHolder{Type: dType, Val: value})
+	}
+	return request
+}
+
+// NewBatchDeleteRequest will generate a new Request for deleting many keys
+func NewBatchDeleteRequest(keys ...string) *Request {
+	return &Request{Op: MDELETE, Keys: keys, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
@@ -0,0 +1,313 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A path locates a value nested within a MAP or ARRAY value using the fields of
+// the maps separated by dots and the indexes of the arrays in brackets e.g.
+// user.addresses[0].city. Negative indexes are counted from the end of the array.
+// As with the fields of a map the values are never changed in place, instead each
+// map and array along the path is copied with the change applied
+
+// pathSegment is a single field or index of a path
+type pathSegment struct {
+	field   string // The field of the map
+	index   int    // The index of the array
+	isIndex bool   // True if the segment is an index rather than a field
+}
+
+// NewPathReadRequest will generate a new Request for reading the value at the path.
+// The value must be of the type specified unless it is NONE
+func NewPathReadRequest(key, path string, dType Type) *Request {
+	return &Request{Op: PGET, Key: key, Path: path, Value: &ValueHolder{Type: dType}, ResponseChannel: make(chan *Response)}
+}
+
+// NewPathWriteRequest will generate a new Request for writing the value at the path.
+// The value must be of the type specified unless it is NONE
+func NewPathWriteRequest(key, path string, dType Type, value interface{}) *Request {
+	return &Request{Op: PSET, Key: key, Path: path, Value: &ValueHolder{Type: dType, Val: value}, ResponseChannel: make(chan *Response)}
+}
+
+// NewPathDeleteRequest will generate a new Request for deleting the value at the path
+func NewPathDeleteRequest(key, path string) *Request {
+	return &Request{Op: PDELETE, Key: key, Path: path, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewPathAppendRequest will generate a new Request for adding the items to the end
+// of the array at the path
+func NewPathAppendRequest(key, path string, items ...interface{}) *Request {
+	return &Request{Op: PAPPEND, Key: key, Path: path, Value: &ValueHolder{Type: ARRAY, Val: items}, ResponseChannel: make(chan *Response)}
+}
+
+// parsePath will split the path into each of its segments
+func parsePath(path string) ([]pathSegment, error) {
+	invalid := generateError(Invalid, fmt.Sprintf("The path '%s' is not valid", path))
+	if path == "" {
+		return nil, invalid
+	}
+	var segments []pathSegment
+	for i := 0; i < len(path); {
+		if path[i] == '[' {
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			index, err := strconv.Atoi(path[i+1 : i+end])
+			if err != nil {
+				return nil, invalid
+			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			if i += end + 1; i < len(path) && path[i] != '[' && path[i] != '.' {
+				return nil, invalid
+			}
+			continue
+		}
+		if path[i] == '.' {
+			if len(segments) == 0 {
+				return nil, invalid
+			}
+			i++
+		}
+		end := strings.IndexAny(path[i:], ".[")
+		if end < 0 {
+			end = len(path) - i
+		}
+		if end == 0 {
+			return nil, invalid
+		}
+		segments = append(segments, pathSegment{field: path[i : i+end]})
+		i += end
+	}
+	return segments, nil
+}
+
+// generatePathError will generate an error for a path that does not exist or
+// does not match the maps and arrays of the value
+func generatePathError(key, path string) error {
+	return generateError(NotFound, fmt.Sprintf("The path '%s' does not exist for key '%s'", path, key))
+}
+
+// errPathNotFound is used within updatePath and replaced with the error for the key
+var errPathNotFound = generateError(NotFound, "The path does not exist")
+
+// getPath returns the value at the path within the value
+func getPath(val interface{}, segments []pathSegment) (interface{}, bool) {
+	for _, segment := range segments {
+		if segment.isIndex {
+			array, ok := val.([]interface{})
+			if !ok {
+				return nil, false
+			}
+			index := segment.index
+			if index < 0 {
+				index += len(array)
+			}
+			if index < 0 || index >= len(array) {
+				return nil, false
+			}
+			val = array[index]
+		} else {
+			fields, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if val, ok = fields[segment.field]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return val, true
+}
+
+// pathUpdate returns the new value at the end of the path from the current value
+// and whether it exists. The value is removed if keep is false
+type pathUpdate func(current interface{}, exists bool) (val interface{}, keep bool, err error)
+
+// updatePath returns a copy of the value with the update applied at the end of the
+// path. Any maps that do not exist along the path are created but an array index
+// must already exist
+func updatePath(val interface{}, exists bool, segments []pathSegment, update pathUpdate) (interface{}, bool, error) {
+	if len(segments) == 0 {
+		return update(val, exists)
+	}
+	segment := segments[0]
+	if segment.isIndex {
+		array, ok := val.([]interface{})
+		index := segment.index
+		if index < 0 {
+			index += len(array)
+		}
+		if !ok || index < 0 || index >= len(array) {
+			return nil, false, errPathNotFound
+		}
+		child, keep, err := updatePath(array[index], true, segments[1:], update)
+		if err != nil {
+			return nil, false, err
+		}
+		updated := make([]interface{}, 0, len(array))
+		updated = append(updated, array[:index]...)
+		if keep {
+			updated = append(updated, child)
+		}
+		return append(updated, array[index+1:]...), true, nil
+	}
+
+	// A map is created if it does not exist
+	fields, ok := val.(map[string]interface{})
+	if !ok && exists {
+		return nil, false, errPathNotFound
+	}
+	current, found := fields[segment.field]
+	child, keep, err := updatePath(current, found, segments[1:], update)
+	if err != nil {
+		return nil, false, err
+	}
+	updated := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		updated[k] = v
+	}
+	if keep {
+		updated[segment.field] = child
+	} else {
+		delete(updated, segment.field)
+	}
+	return updated, true, nil
+}
+
+// updateAtPath will apply the update at the end of the path for the value of the key
+func (s *Store) updateAtPath(key, path string, update pathUpdate) error {
+	segments, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	current, exists := s.access(key)
+	if exists && TypeOf(current) != MAP && TypeOf(current) != ARRAY {
+		return generateTypeError(key)
+	}
+	val, _, err := updatePath(current, exists, segments, update)
+	if err == errPathNotFound {
+		return generatePathError(key, path)
+	} else if err != nil {
+		return err
+	}
+	s.updateValue(key, val)
+	return nil
+}
+
+// PGet returns the value at the path within the MAP or ARRAY value of the key
+func (s *Store) PGet(key, path string) (interface{}, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := s.GetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	if t := TypeOf(raw); t != MAP && t != ARRAY {
+		return nil, generateTypeError(key)
+	}
+	val, ok := getPath(raw, segments)
+	if !ok {
+		return nil, generatePathError(key, path)
+	}
+	return val, nil
+}
+
+// PSet will store the value at the path within the MAP or ARRAY value of the key.
+// Any maps along the path that do not exist are created (including the key)
+func (s *Store) PSet(key, path string, value interface{}) error {
+	value, err := normalizeItem(value)
+	if err != nil {
+		return err
+	}
+	return s.updateAtPath(key, path, func(interface{}, bool) (interface{}, bool, error) {
+		return value, true, nil
+	})
+}
+
+// PDelete will delete the value at the path within the MAP or ARRAY value of the key
+func (s *Store) PDelete(key, path string) error {
+	return s.updateAtPath(key, path, func(_ interface{}, exists bool) (interface{}, bool, error) {
+		if !exists {
+			return nil, false, errPathNotFound
+		}
+		return nil, false, nil
+	})
+}
+
+// PAppend will add the items to the end of the array at the path within the MAP
+// or ARRAY value of the key and return the new length. The array is created if
+// it does not exist
+func (s *Store) PAppend(key, path string, items ...interface{}) (int, error) {
+	items, err := normalizeItems(items)
+	if err != nil {
+		return 0, err
+	}
+	var length int
+	err = s.updateAtPath(key, path, func(current interface{}, exists bool) (interface{}, bool, error) {
+		array, ok := current.([]interface{})
+		if exists && !ok {
+			return nil, false, generateError(WrongType, fmt.Sprintf("The value at path '%s' for key '%s' is not an array", path, key))
+		}
+		updated := make([]interface{}, 0, len(array)+len(items))
+		updated = append(append(updated, array...), items...)
+		length = len(updated)
+		return updated, true, nil
+	})
+	return length, err
+}
+
+// path will read, write, delete or append to the value at the path of the
+// request. The type of the value is checked unless the type is NONE
+func (sh *shard) path(request *Request, response *Response) {
+	dType := request.Value.Type
+	var err error
+	switch request.Op {
+	case PGET:
+		var val interface{}
+		if val, err = sh.store.PGet(request.Key, request.Path); err == nil {
+			if dType != NONE && TypeOf(val) != dType {
+				err = generateError(WrongType, fmt.Sprintf("The value at path '%s' for key '%s' is not of the correct type", request.Path, request.Key))
+			} else {
+				response.Value = &ValueHolder{Type: TypeOf(val), Val: val}
+			}
+		}
+	case PSET:
+		var val interface{}
+		if val, err = normalizeItem(request.Value.Val); err != nil {
+			break
+		}
+		if _, convertErr := ConvertValue(dType, val); dType != NONE && convertErr != nil {
+			err = generateError(WrongType, fmt.Sprintf("The value for path '%s' is not of the correct type", request.Path))
+		} else if err = sh.store.PSet(request.Key, request.Path, val); err == nil {
+			sh.changed(request.Key)
+		}
+	case PDELETE:
+		if err = sh.store.PDelete(request.Key, request.Path); err == nil {
+			sh.changed(request.Key)
+		}
+	case PAPPEND:
+		items, ok := request.Value.Val.([]interface{})
+		if !ok {
+			items = []interface{}{request.Value.Val}
+		}
+		var length int
+		if length, err = sh.store.PAppend(request.Key, request.Path, items...); err == nil {
+			sh.changed(request.Key)
+			response.Value = &ValueHolder{Type: INT, Val: length}
+		}
+	}
+	if err == nil {
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
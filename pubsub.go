@@ -0,0 +1,142 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"log"
+	"path"
+)
+
+// The topics are separate from the keys of the store. A message published to a
+// topic is sent to the subscribers at that moment and is never stored, so a
+// subscriber that is not connected or is too slow will miss it
+
+// DefaultSubscribeBuffer is the number of messages that can be waiting to be read
+// from a subscription. A subscription that falls this far behind is closed
+// rather than holding up the service
+const DefaultSubscribeBuffer = 256
+
+// ErrSubscriptionClosed is sent by the transports once a watch or subscription
+// has been closed by the service
+var ErrSubscriptionClosed error = &Error{Code: Unavailable, Message: "The subscription has been closed"}
+
+// Message is a value published to a topic
+type Message struct {
+	Topic   string       // The topic the message was published to
+	Pattern string       // The pattern of the subscription that matched the topic
+	Value   *ValueHolder // The value published
+}
+
+// subscriber holds the messages channel of a subscription along with its patterns
+type subscriber struct {
+	id       uint64
+	patterns []string
+	messages chan *Message
+}
+
+// NewPublishRequest will generate a new Request for publishing the value to the topic
+func NewPublishRequest(topic string, dType Type, value interface{}) *Request {
+	return &Request{Op: PUBLISH, Topic: topic, Value: &ValueHolder{Type: dType, Val: value}, ResponseChannel: make(chan *Response)}
+}
+
+// NewSubscribeRequest will generate a new Request for receiving the messages
+// published to any topic that matches one of the patterns. The patterns use the
+// syntax of path.Match so a topic without any special characters matches only
+// itself. The messages are sent on the channel provided which is closed once
+// the subscription has ended
+func NewSubscribeRequest(messages chan *Message, patterns ...string) *Request {
+	return &Request{Op: SUBSCRIBE, Patterns: patterns, Messages: messages, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewUnsubscribeRequest will generate a new Request for ending the subscription
+func NewUnsubscribeRequest(id uint64) *Request {
+	return &Request{Op: UNSUBSCRIBE, Subscription: id, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// match returns the first of the patterns that matches the topic
+func (s *subscriber) match(topic string) (string, bool) {
+	for _, pattern := range s.patterns {
+		if matched, _ := path.Match(pattern, topic); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// subscribe will start sending the messages for the patterns of the request
+func (ks *Service) subscribe(request *Request, response *Response) {
+	if request.Messages == nil {
+		response.fail(generateError(Invalid, "A channel is required to receive the messages of a subscription"))
+		return
+	}
+	if len(request.Patterns) == 0 {
+		response.fail(generateError(Invalid, "At least one topic or pattern is required for a subscription"))
+		return
+	}
+	for _, pattern := range request.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			response.fail(generateError(Invalid, fmt.Sprintf("The pattern '%s' is not valid", pattern)))
+			return
+		}
+	}
+	ks.lastSubscription++
+	s := &subscriber{id: ks.lastSubscription, patterns: request.Patterns, messages: request.Messages}
+	ks.subscribers[s.id] = s
+	response.Subscription = s.id
+	response.Success = true
+}
+
+// unsubscribe will end the subscription of the request
+func (ks *Service) unsubscribe(request *Request, response *Response) {
+	if !ks.closeSubscription(request.Subscription) {
+		response.fail(generateError(NotFound, fmt.Sprintf("The subscription %d does not exist", request.Subscription)))
+		return
+	}
+	response.Success = true
+}
+
+// closeSubscription will stop sending messages to the subscription and close its channel
+func (ks *Service) closeSubscription(id uint64) bool {
+	s, ok := ks.subscribers[id]
+	if ok {
+		delete(ks.subscribers, id)
+		close(s.messages)
+	}
+	return ok
+}
+
+// publish will send the value of the request to each subscription with a
+// pattern that matches the topic. The number of subscriptions that received
+// the message is returned. As with a watch the service will never wait for a
+// subscription so any that have not read their previous messages are closed
+func (ks *Service) publish(request *Request, response *Response) {
+	if request.Topic == "" {
+		response.fail(generateError(Invalid, "A topic is required to publish a message"))
+		return
+	}
+	var received int
+	for id, s := range ks.subscribers {
+		pattern, ok := s.match(request.Topic)
+		if !ok {
+			continue
+		}
+		select {
+		case s.messages <- &Message{Topic: request.Topic, Pattern: pattern, Value: request.Value}:
+			received++
+		default:
+			log.Printf("Closing subscription %d as it is not reading the messages", id)
+			ks.closeSubscription(id)
+		}
+	}
+	response.Value = &ValueHolder{Type: INT, Val: received}
+	response.Success = true
+}
+
+// stopSubscribers will close every subscription as the service is stopping
+func (ks *Service) stopSubscribers() {
+	for id := range ks.subscribers {
+		ks.closeSubscription(id)
+	}
+}
@@ -0,0 +1,93 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// nextMessage returns the next message of the subscription, or nil once it has closed
+func nextMessage(t *testing.T, messages <-chan *Message) *Message {
+	t.Helper()
+	select {
+	case message := <-messages:
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("No message was received from the subscription")
+	}
+	return nil
+}
+
+func TestPublishToPatterns(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	_, messages, err := ks.Subscribe("cache.*", "events")
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	for topic, expected := range map[string]int{"cache.users": 1, "events": 1, "other": 0, "cache": 0} {
+		if received, err := ks.Publish(topic, topic); err != nil || received != expected {
+			t.Errorf("Publish(%s) = %d, %v, expected %d", topic, received, err, expected)
+		}
+	}
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		message := nextMessage(t, messages)
+		received[message.Topic] = message.Pattern
+		if message.Value == nil || message.Value.Val != message.Topic {
+			t.Errorf("The message for %s holds %+v", message.Topic, message.Value)
+		}
+	}
+	if received["cache.users"] != "cache.*" || received["events"] != "events" {
+		t.Errorf("Received messages for %v, expected cache.users and events", received)
+	}
+	if _, err := ks.GetValue("events"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetValue(events) returned %v, expected the message not to be stored", err)
+	}
+}
+
+func TestSubscriptionTeardown(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	id, messages, err := ks.Subscribe("topic")
+	if err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	if err = ks.Unsubscribe(id); err != nil {
+		t.Fatalf("Unsubscribe: %s", err)
+	}
+	if message := nextMessage(t, messages); message != nil {
+		t.Errorf("Received %+v, expected the channel to be closed by Unsubscribe", message)
+	}
+	if err = ks.Unsubscribe(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unsubscribe of an ended subscription returned %v, expected it not to be found", err)
+	}
+	if received, err := ks.Publish("topic", 1); err != nil || received != 0 {
+		t.Errorf("Publish = %d, %v, expected no subscribers", received, err)
+	}
+
+	// A subscription that does not read its messages is closed
+	slow := make(chan *Message, 1)
+	if _, err = ks.SendAsync(NewSubscribeRequest(slow, "topic")).Wait(); err != nil {
+		t.Fatalf("Subscribe: %s", err)
+	}
+	ks.Publish("topic", 1)
+	ks.Publish("topic", 2)
+	if message := nextMessage(t, slow); message == nil || message.Value.Val != 1 {
+		t.Errorf("Received %+v, expected the message that was buffered", message)
+	}
+	if message := nextMessage(t, slow); message != nil {
+		t.Errorf("Received %+v, expected the slow subscription to be closed", message)
+	}
+
+	// Every subscription is closed once the service stops
+	_, messages, _ = ks.Subscribe("topic")
+	<-ks.Stop()
+	if message := nextMessage(t, messages); message != nil {
+		t.Errorf("Received %+v, expected the subscription to be closed when the service stopped", message)
+	}
+}
@@ -0,0 +1,63 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// record is a single value as it is written to disk. The type is stored next
+// to the value so that it will be restored as the same type it was written with
+// (otherwise every number would be read back as a float)
+type record struct {
+	Type    Type            `json:",omitempty"`
+	Val     json.RawMessage `json:",omitempty"`
+	Expires *time.Time      `json:",omitempty"`
+	Version uint64          `json:",omitempty"`
+}
+
+// TypeOf returns the data type for the value or NONE if it is not one of the
+// specific types supported by the store
+func TypeOf(val interface{}) Type {
+	switch val.(type) {
+	case bool:
+		return BOOL
+	case int:
+		return INT
+	case float64:
+		return FLOAT
+	case string:
+		return STRING
+	case []interface{}:
+		return ARRAY
+	case map[string]interface{}:
+		return MAP
+	case Set:
+		return SET
+	case ZSet:
+		return ZSET
+	}
+	return NONE
+}
+
+// newRecord will create the record for the key that is to be written to disk
+func (s *Store) newRecord(key string) (*record, error) {
+	val := s.values[key]
+	b, err := MarshalValue(val)
+	if err != nil {
+		return nil, err
+	}
+	r := &record{Type: TypeOf(val), Val: b, Version: s.versions[key]}
+	if expires, ok := s.expires[key]; ok {
+		r.Expires = &expires
+	}
+	return r, nil
+}
+
+// value will decode the value as the type it was written with. Any value written
+// before the type was recorded is decoded in to its canonical form
+func (r *record) value() (interface{}, error) {
+	return decodeValue(r.Type, r.Val)
+}
@@ -6,13 +6,34 @@ package keystore
 import (
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultSweepInterval is how often the service will remove any expired keys
+const DefaultSweepInterval = time.Second
+
+// DefaultSyncInterval is how often the write ahead log is flushed to disk when
+// using the SyncInterval policy
+const DefaultSyncInterval = time.Second
+
 // Service is the wrapper for the in-memory data store service
 type Service struct {
-	*Sync                // Adopt the sync struct
-	store *Store         // The in-memory store
-	quit  chan chan bool // Uses the channel as a signal to shutdown
+	*Sync                                   // Adopt the sync struct
+	store            *Store                 // The in-memory store
+	shards           []*shard               // The shards the keys of the store are divided between
+	shardCount       int                    // The number of shards
+	quit             chan chan bool         // Uses the channel as a signal to shutdown
+	sweepInterval    time.Duration          // How often the expired keys are removed
+	syncInterval     time.Duration          // How often the write ahead log is flushed
+	autosave         time.Duration          // How often the store is saved if it has changed
+	autosaveAfter    int                    // The number of writes that will trigger a save
+	writes           int64                  // The number of writes since the store was saved
+	watchers         map[uint64]*watcher    // The watches that receive an event for each change
+	watchMutex       sync.Mutex             // Guards the watches as each shard sends their events
+	subscribers      map[uint64]*subscriber // The subscriptions that receive the messages published to topics
+	lastSubscription uint64                 // The id of the last watch or subscription
 }
 
 // NewService will initialise a new keystore
@@ -21,48 +42,221 @@ type Service struct {
 func NewService(filePath string) *Service {
 
 	// Create a new instance of the key store
-	return &Service{&Sync{make(chan *Request)}, NewStoreFromFile(filePath), make(chan chan bool)}
+	return &Service{
+		Sync:          &Sync{make(chan *Request)},
+		store:         NewStoreFromFile(filePath),
+		quit:          make(chan chan bool),
+		shardCount:    DefaultShards,
+		sweepInterval: DefaultSweepInterval,
+		syncInterval:  DefaultSyncInterval,
+		watchers:      make(map[uint64]*watcher),
+		subscribers:   make(map[uint64]*subscriber),
+	}
+}
+
+// UpdateSweepInterval will change how often the expired keys are removed from
+// the store. An interval that is not positive is ignored. It must be called
+// before the service is started
+func (ks *Service) UpdateSweepInterval(interval time.Duration) {
+	if interval > 0 {
+		ks.sweepInterval = interval
+	}
+}
+
+// UpdateSyncPolicy will change how often the write ahead log is flushed to disk.
+// The interval is only used by the SyncInterval policy. It must be called before
+// the service is started
+func (ks *Service) UpdateSyncPolicy(policy SyncPolicy, interval time.Duration) {
+	ks.store.UpdateSyncPolicy(policy)
+	if interval > 0 {
+		ks.syncInterval = interval
+	}
+}
+
+// UpdateCompactionSize will change the size in bytes the write ahead log can grow
+// to before it is compacted into a new snapshot. Zero disables compaction
+func (ks *Service) UpdateCompactionSize(size int64) {
+	ks.store.UpdateCompactionSize(size)
+}
+
+// UpdateBackupCount will change the number of previous snapshots that are kept
+// and used to recover the store if the snapshot is corrupt
+func (ks *Service) UpdateBackupCount(count int) {
+	ks.store.UpdateBackupCount(count)
+}
+
+// UpdateFormat will change the file format used when the store is saved to disk.
+// The format of an existing file is detected when it is read
+func (ks *Service) UpdateFormat(format Format) {
+	ks.store.UpdateFormat(format)
+}
+
+// UpdateMaxKeys will change the maximum number of keys in the store. Once the
+// limit is reached keys are evicted using the eviction policy. Zero removes
+// the limit. It must be called before the service is started
+func (ks *Service) UpdateMaxKeys(max int) {
+	ks.store.UpdateMaxKeys(max)
+}
+
+// UpdateMaxMemory will change the maximum approximate size in bytes of the store.
+// Once the limit is reached keys are evicted using the eviction policy. Zero
+// removes the limit. It must be called before the service is started
+func (ks *Service) UpdateMaxMemory(max int64) {
+	ks.store.UpdateMaxMemory(max)
+}
+
+// UpdateEvictionPolicy will change how keys are removed once the store has
+// reached a limit. With NoEviction any write that needs more room is rejected
+// with ErrStoreFull. It must be called before the service is started
+func (ks *Service) UpdateEvictionPolicy(policy EvictionPolicy) {
+	ks.store.UpdateEvictionPolicy(policy)
+}
+
+// UpdateAutosave will save the store to disk every interval if it has changed
+// and after the number of writes specified. Either can be disabled using zero.
+// It must be called before the service is started
+func (ks *Service) UpdateAutosave(interval time.Duration, writes int) {
+	ks.autosave = interval
+	ks.autosaveAfter = writes
 }
 
 // Start will bootstrap the keystore service ready to receive requests
 func (ks *Service) Start() {
 	log.Println("Starting Keystore Service")
-	ks.store.ReadFromDisk()
+	if err := ks.store.ReadFromDisk(); err != nil {
+		log.Println(fmt.Errorf("Error reading values from disk: %s", err.Error()))
+	}
+
+	// Each shard applies the requests for its own keys in its own routine
+	ks.shards = make([]*shard, ks.shardCount)
+	for i, part := range ks.store.split(ks.shardCount) {
+		ks.shards[i] = newShard(ks, part)
+		ks.shards[i].start(ks.sweepInterval)
+	}
 
 	// Spawn the store handler in a new go routine that will sit and wait for
 	// operation requests. It is concurrently safe using channel blocking
 	// for operations
 	go func() {
 
+		// The write ahead log is flushed periodically (only used for the interval policy)
+		syncer := time.NewTicker(ks.syncInterval)
+		defer syncer.Stop()
+
+		// The store is only saved periodically if autosave has been enabled
+		var autosave <-chan time.Time
+		if ks.autosave > 0 {
+			ticker := time.NewTicker(ks.autosave)
+			defer ticker.Stop()
+			autosave = ticker.C
+		}
+
 		// Loop until it receives a message on the quite channel
 		for {
 			select {
 			case request := <-ks.RequestChannel:
-				response := &Response{}
-				// A request has been made to perform an operation on the store
-				switch request.Op {
-				case READ:
-					ks.readValue(request, response)
-				case WRITE:
-					ks.writeValue(request, response)
-				case DELETE:
-					ks.deleteKey(request, response)
+				// A request has been made to perform an operation on the store.
+				// Unless the request uses many keys it is added to the queue of the
+				// shard of the key, which never waits for the shard. Any request that
+				// the caller has stopped waiting for is dropped
+				if err := request.Err(); err != nil {
+					respond(request, &Response{Error: err.Error(), Code: CodeOf(err)})
+				} else if request.Op&serviceOperations == 0 {
+					ks.shardFor(request.Key).queue.push(request)
+				} else {
+					response := &Response{}
+					ks.apply(request, response)
+					respond(request, response)
+				}
+			case <-autosave:
+				if atomic.LoadInt64(&ks.writes) > 0 {
+					ks.pauseAndSave()
+				}
+			case <-syncer.C:
+				if err := ks.store.SyncLog(); err != nil {
+					log.Println(fmt.Errorf("Error flushing the log to disk: %s", err.Error()))
 				}
-
-				// Send the response over the response channel
-				go func() {
-					request.ResponseChannel <- response
-				}()
 			case complete := <-ks.quit:
-				// The signal to shutdown has been received
+				// The signal to shutdown has been received so the shards are
+				// paused and then stopped once any waiting requests have ended
+				b := ks.pause()
+				for _, sh := range ks.shards {
+					sh.stopWaiters()
+				}
+				ks.stopWatchers()
+				ks.stopSubscribers()
 
 				// Write the values to disk
 				if err := ks.store.SaveToDisk(); err != nil {
 					log.Println(fmt.Errorf("Error saving values to disk: %s", err.Error()))
 				}
+				if err := ks.store.Close(); err != nil {
+					log.Println(fmt.Errorf("Error closing the log: %s", err.Error()))
+				}
+				b.stop = true
+				close(b.resume)
 				complete <- true
 				return
 			}
+
+			// Save the store once enough writes have been made or the log has
+			// grown large enough to be compacted
+			if ks.autosaveAfter > 0 && atomic.LoadInt64(&ks.writes) >= int64(ks.autosaveAfter) {
+				ks.pauseAndSave()
+			} else if ks.store.compactionDue() {
+				log.Printf("Compacting the log %s", ks.store.logPath())
+				ks.pauseAndSave()
+			}
+		}
+	}()
+}
+
+// apply will perform the operation for a request that uses many keys, or none
+// at all. The shards are paused first if the request must see all of their keys
+func (ks *Service) apply(request *Request, response *Response) {
+	if request.Op&pausingOperations != 0 {
+		b := ks.pause()
+		defer ks.release(b)
+
+		// The request is dropped if the deadline passed whilst the shards paused
+		if err := request.Err(); err != nil {
+			response.Error, response.Code = err.Error(), CodeOf(err)
+			return
+		}
+	}
+	switch request.Op {
+	case FLUSH:
+		ks.flush(response)
+	case SCAN:
+		ks.scan(request, response)
+	case TRANSACTION:
+		ks.transaction(request, response)
+	case MREAD, MWRITE, MDELETE:
+		ks.batch(request, response)
+	case SUNION, SINTER, SDIFF:
+		ks.combine(request, response)
+	case STATS:
+		ks.stats(response)
+	case WATCH:
+		ks.watch(request, response)
+	case UNWATCH:
+		ks.unwatch(request, response)
+	case PUBLISH:
+		ks.publish(request, response)
+	case SUBSCRIBE:
+		ks.subscribe(request, response)
+	case UNSUBSCRIBE:
+		ks.unsubscribe(request, response)
+	}
+}
+
+// respond will send the response over the response channel of the request
+// unless the caller has stopped waiting for it
+func respond(request *Request, response *Response) {
+	go func() {
+		select {
+		case request.ResponseChannel <- response:
+		case <-request.Done:
 		}
 	}()
 }
@@ -81,7 +275,7 @@ func (ks *Service) Stop() chan bool {
 
 // readValue will return the value from the store for the particular
 // type and put that value into the Response
-func (ks *Service) readValue(request *Request, response *Response) {
+func (sh *shard) readValue(request *Request, response *Response) {
 
 	// Create a new value holder for this response
 	response.Value = &ValueHolder{Type: request.Value.Type}
@@ -92,19 +286,30 @@ func (ks *Service) readValue(request *Request, response *Response) {
 	// is received.
 	switch request.Value.Type {
 	case BOOL:
-		response.Value.Val, err = ks.store.GetBool(request.Key)
+		response.Value.Val, err = sh.store.GetBool(request.Key)
 	case INT:
-		response.Value.Val, err = ks.store.GetInt(request.Key)
+		response.Value.Val, err = sh.store.GetInt(request.Key)
 	case FLOAT:
-		response.Value.Val, err = ks.store.GetFloat(request.Key)
+		response.Value.Val, err = sh.store.GetFloat(request.Key)
 	case STRING:
-		response.Value.Val, err = ks.store.GetString(request.Key)
+		response.Value.Val, err = sh.store.GetString(request.Key)
 	case ARRAY:
-		response.Value.Val, err = ks.store.GetArray(request.Key)
+		response.Value.Val, err = sh.store.GetArray(request.Key)
 	case MAP:
-		response.Value.Val, err = ks.store.GetMap(request.Key)
+		response.Value.Val, err = sh.store.GetMap(request.Key)
+	case SET:
+		response.Value.Val, err = sh.store.GetSet(request.Key)
+	case ZSET:
+		response.Value.Val, err = sh.store.GetZSet(request.Key)
 	default:
-		response.Value.Val, err = ks.store.GetValue(request.Key)
+		// The type of the value is returned so that it can be restored by the
+		// transports that do not keep the Go type of the value
+		response.Value.Val, err = sh.store.GetValue(request.Key)
+		response.Value.Type = TypeOf(response.Value.Val)
+	}
+	if err == nil {
+		response.Value.TTL, err = sh.store.TTL(request.Key)
+		response.Version = sh.store.Version(request.Key)
 	}
 
 	// if no error occurred during this operation then the request was a success
@@ -112,12 +317,12 @@ func (ks *Service) readValue(request *Request, response *Response) {
 		response.Success = true
 	} else {
 		response.Success = false
-		response.Error = err.Error()
+		response.fail(err)
 	}
 }
 
 // writeValue will write a value to the store
-func (ks *Service) writeValue(request *Request, response *Response) {
+func (sh *shard) writeValue(request *Request, response *Response) {
 	var err error
 
 	// The value passed to all methods is of type interface{} but they each
@@ -125,21 +330,32 @@ func (ks *Service) writeValue(request *Request, response *Response) {
 	// ensure that the specific value type is correct without this having to be
 	// done on each end of the request and response. The receiver will only have to
 	// assert the type when they are receiving NONE particular type
+	ttl := request.Value.TTL
 	switch request.Value.Type {
 	case BOOL:
-		err = ks.store.SetBool(request.Key, request.Value.Val)
+		err = sh.store.SetBoolWithTTL(request.Key, request.Value.Val, ttl)
 	case INT:
-		err = ks.store.SetInt(request.Key, request.Value.Val)
+		err = sh.store.SetIntWithTTL(request.Key, request.Value.Val, ttl)
 	case FLOAT:
-		err = ks.store.SetFloat(request.Key, request.Value.Val)
+		err = sh.store.SetFloatWithTTL(request.Key, request.Value.Val, ttl)
 	case STRING:
-		err = ks.store.SetString(request.Key, request.Value.Val)
+		err = sh.store.SetStringWithTTL(request.Key, request.Value.Val, ttl)
 	case ARRAY:
-		err = ks.store.SetArray(request.Key, request.Value.Val)
+		err = sh.store.SetArrayWithTTL(request.Key, request.Value.Val, ttl)
 	case MAP:
-		err = ks.store.SetMap(request.Key, request.Value.Val)
+		err = sh.store.SetMapWithTTL(request.Key, request.Value.Val, ttl)
+	case SET:
+		err = sh.store.SetSetWithTTL(request.Key, request.Value.Val, ttl)
+	case ZSET:
+		err = sh.store.SetZSetWithTTL(request.Key, request.Value.Val, ttl)
 	default:
-		ks.store.SetValue(request.Key, request.Value.Val)
+		err = sh.store.SetValueWithTTL(request.Key, request.Value.Val, ttl)
+	}
+
+	// Record the write so that it will survive a crash
+	if err == nil {
+		sh.changed(request.Key)
+		response.Version = sh.store.Version(request.Key)
 	}
 
 	// if no error occurred during this operation then the request was a success
@@ -147,14 +363,98 @@ func (ks *Service) writeValue(request *Request, response *Response) {
 		response.Success = true
 	} else {
 		response.Success = false
-		response.Error = err.Error()
+		response.fail(err)
 	}
 }
 
 // deleteKey will delete the key and value from the store
-func (ks *Service) deleteKey(request *Request, response *Response) {
+func (sh *shard) deleteKey(request *Request, response *Response) {
 
 	// Then delete the key if it is present
-	ks.store.DeleteKey(request.Key)
+	sh.store.DeleteKey(request.Key)
+	sh.changed(request.Key)
+	response.Success = true
+}
+
+// batch will apply the operation to each of the keys in turn using the shard
+// of each key. Unlike a transaction each key is independent so a failure will
+// not stop the others
+func (ks *Service) batch(request *Request, response *Response) {
+	var op Op
+	switch request.Op {
+	case MREAD:
+		op = READ
+	case MWRITE:
+		op = WRITE
+		if len(request.Values) != len(request.Keys) {
+			response.fail(generateError(Invalid, "A value must be provided for each of the keys"))
+			return
+		}
+	case MDELETE:
+		op = DELETE
+	}
+	dType := NONE
+	if request.Value != nil {
+		dType = request.Value.Type
+	}
+	response.Results = make([]*Response, len(request.Keys))
+	for i, key := range request.Keys {
+		value := &ValueHolder{Type: dType}
+		if op == WRITE && request.Values[i] != nil {
+			value = request.Values[i]
+		}
+		response.Results[i] = &Response{}
+		ks.shardFor(key).apply(&Request{Op: op, Key: key, Value: value}, response.Results[i])
+	}
 	response.Success = true
 }
+
+// compareAndSwap will write the value only if the current version of the key
+// matches the version expected by the request
+func (sh *shard) compareAndSwap(request *Request, response *Response) {
+	current := sh.store.Version(request.Key)
+	if request.Version == current || (request.Version == AnyVersion && current > 0) {
+		sh.writeValue(request, response)
+	} else {
+		response.Success = false
+		response.fail(ErrVersionConflict)
+		response.Version = current
+	}
+}
+
+// flush will write the store to disk
+func (ks *Service) flush(response *Response) {
+	if err := ks.save(); err != nil {
+		response.fail(err)
+	} else {
+		response.Success = true
+	}
+}
+
+// scan will list the keys in order
+func (ks *Service) scan(request *Request, response *Response) {
+	options := ScanOptions{}
+	if request.Scan != nil {
+		options = *request.Scan
+	}
+	response.Keys, response.Cursor = ks.store.Scan(options)
+	response.Success = true
+}
+
+// save will write the store to disk and reset the number of writes
+func (ks *Service) save() error {
+	err := ks.store.SaveToDisk()
+	if err != nil {
+		log.Println(fmt.Errorf("Error saving values to disk: %s", err.Error()))
+	} else {
+		atomic.StoreInt64(&ks.writes, 0)
+	}
+	return err
+}
+
+// pauseAndSave will save the store to disk whilst the shards are paused
+func (ks *Service) pauseAndSave() {
+	b := ks.pause()
+	defer ks.release(b)
+	ks.save()
+}
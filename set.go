@@ -0,0 +1,306 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"sort"
+)
+
+// Set is a SET value of unique members. The members are held in order so that
+// a member can be found quickly and so that sets can be merged. As with the other
+// values a set is never changed in place, a new set is created for each change
+type Set []string
+
+// NewSet creates a set of the unique members
+func NewSet(members ...string) Set {
+	unique := make(map[string]bool, len(members))
+	set := make(Set, 0, len(members))
+	for _, member := range members {
+		if !unique[member] {
+			unique[member] = true
+			set = append(set, member)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
+
+// Contains returns whether the member is within the set
+func (set Set) Contains(member string) bool {
+	i := sort.SearchStrings(set, member)
+	return i < len(set) && set[i] == member
+}
+
+// Union returns a new set of the members that are within either set
+func (set Set) Union(other Set) Set {
+	union := make(Set, 0, len(set)+len(other))
+	i, j := 0, 0
+	for i < len(set) || j < len(other) {
+		switch {
+		case j == len(other) || (i < len(set) && set[i] < other[j]):
+			union = append(union, set[i])
+			i++
+		case i == len(set) || other[j] < set[i]:
+			union = append(union, other[j])
+			j++
+		default:
+			union = append(union, set[i])
+			i++
+			j++
+		}
+	}
+	return union
+}
+
+// Intersect returns a new set of the members that are within both sets
+func (set Set) Intersect(other Set) Set {
+	intersection := Set{}
+	for i, j := 0, 0; i < len(set) && j < len(other); {
+		switch {
+		case set[i] < other[j]:
+			i++
+		case other[j] < set[i]:
+			j++
+		default:
+			intersection = append(intersection, set[i])
+			i++
+			j++
+		}
+	}
+	return intersection
+}
+
+// Diff returns a new set of the members that are within the set but not the other
+func (set Set) Diff(other Set) Set {
+	diff := Set{}
+	for _, member := range set {
+		if !other.Contains(member) {
+			diff = append(diff, member)
+		}
+	}
+	return diff
+}
+
+// toSet returns the value as a set. The members of a set that has been decoded
+// from json will be an array of strings
+func toSet(val interface{}) (Set, bool) {
+	switch v := val.(type) {
+	case Set:
+		return NewSet(v...), true
+	case []string:
+		return NewSet(v...), true
+	case []interface{}:
+		members := make([]string, len(v))
+		for i, member := range v {
+			var ok bool
+			if members[i], ok = member.(string); !ok {
+				return nil, false
+			}
+		}
+		return NewSet(members...), true
+	}
+	return nil, false
+}
+
+// NewSetRequest will generate a new Request for adding (SADD) or removing (SREM)
+// the members of the set
+func NewSetRequest(op Op, key string, members ...string) *Request {
+	return &Request{Op: op, Key: key, Value: &ValueHolder{Type: SET, Val: members}, ResponseChannel: make(chan *Response)}
+}
+
+// NewIsMemberRequest will generate a new Request for checking the member is within the set
+func NewIsMemberRequest(key, member string) *Request {
+	return &Request{Op: SISMEMBER, Key: key, Value: &ValueHolder{Type: BOOL, Val: member}, ResponseChannel: make(chan *Response)}
+}
+
+// NewMembersRequest will generate a new Request for reading the members of the set
+func NewMembersRequest(key string) *Request {
+	return &Request{Op: SMEMBERS, Key: key, Value: &ValueHolder{Type: SET}, ResponseChannel: make(chan *Response)}
+}
+
+// NewSetCombineRequest will generate a new Request for the union (SUNION), intersection
+// (SINTER) or difference (SDIFF) of the sets. The difference is the members of the
+// first set that are not in any of the others
+func NewSetCombineRequest(op Op, keys ...string) *Request {
+	return &Request{Op: op, Keys: keys, Value: &ValueHolder{Type: SET}, ResponseChannel: make(chan *Response)}
+}
+
+// getSet returns the set for the key. A key that does not exist is an empty set
+func (s *Store) getSet(key string) (Set, error) {
+	raw, exists := s.access(key)
+	if !exists {
+		return Set{}, nil
+	}
+	set, ok := raw.(Set)
+	if !ok {
+		return nil, generateTypeError(key)
+	}
+	return set, nil
+}
+
+// SAdd will add the members to the set returning the number that were not
+// already members. A key that does not exist is created
+func (s *Store) SAdd(key string, members ...string) (int, error) {
+	set, err := s.getSet(key)
+	if err != nil {
+		return 0, err
+	}
+	updated := set.Union(NewSet(members...))
+	added := len(updated) - len(set)
+	if added > 0 {
+		s.updateValue(key, updated)
+	}
+	return added, nil
+}
+
+// SRem will remove the members from the set returning the number that were removed
+func (s *Store) SRem(key string, members ...string) (int, error) {
+	set, err := s.getSet(key)
+	if err != nil {
+		return 0, err
+	}
+	updated := set.Diff(NewSet(members...))
+	removed := len(set) - len(updated)
+	if removed > 0 {
+		s.updateValue(key, updated)
+	}
+	return removed, nil
+}
+
+// SIsMember returns whether the member is within the set
+func (s *Store) SIsMember(key, member string) (bool, error) {
+	set, err := s.getSet(key)
+	return set.Contains(member), err
+}
+
+// SMembers returns the members of the set in order
+func (s *Store) SMembers(key string) (Set, error) {
+	return s.getSet(key)
+}
+
+// SUnion returns the members that are within any of the sets
+func (s *Store) SUnion(keys ...string) (Set, error) {
+	union := Set{}
+	for _, key := range keys {
+		set, err := s.getSet(key)
+		if err != nil {
+			return nil, err
+		}
+		union = union.Union(set)
+	}
+	return union, nil
+}
+
+// SInter returns the members that are within every one of the sets
+func (s *Store) SInter(keys ...string) (Set, error) {
+	var intersection Set
+	for i, key := range keys {
+		set, err := s.getSet(key)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			intersection = set
+		} else {
+			intersection = intersection.Intersect(set)
+		}
+	}
+	if intersection == nil {
+		intersection = Set{}
+	}
+	return intersection, nil
+}
+
+// SDiff returns the members of the first set that are not within any of the others
+func (s *Store) SDiff(keys ...string) (Set, error) {
+	var diff Set
+	for i, key := range keys {
+		set, err := s.getSet(key)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			diff = set
+		} else {
+			diff = diff.Diff(set)
+		}
+	}
+	if diff == nil {
+		diff = Set{}
+	}
+	return diff, nil
+}
+
+// set will apply the SET operation of the request
+func (sh *shard) set(request *Request, response *Response) {
+	var err error
+	switch request.Op {
+	case SADD, SREM:
+		members, ok := toSet(request.Value.Val)
+		if !ok {
+			if member, isString := request.Value.Val.(string); isString {
+				members, ok = Set{member}, true
+			}
+		}
+		if !ok {
+			err = generateError(Invalid, "The members must be strings")
+			break
+		}
+		var count int
+		if request.Op == SADD {
+			count, err = sh.store.SAdd(request.Key, members...)
+		} else {
+			count, err = sh.store.SRem(request.Key, members...)
+		}
+		if err == nil {
+			if count > 0 {
+				sh.changed(request.Key)
+			}
+			response.Value = &ValueHolder{Type: INT, Val: count}
+		}
+	case SISMEMBER:
+		member, _ := request.Value.Val.(string)
+		var isMember bool
+		if isMember, err = sh.store.SIsMember(request.Key, member); err == nil {
+			response.Value = &ValueHolder{Type: BOOL, Val: isMember}
+		}
+	case SMEMBERS:
+		var set Set
+		if set, err = sh.store.SMembers(request.Key); err == nil {
+			response.Value = &ValueHolder{Type: SET, Val: set}
+		}
+	}
+	if err == nil {
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}
+
+// combine will apply the SUNION, SINTER or SDIFF operation of the request. The
+// sets may be held by different shards so each is read from the shard of its key
+func (ks *Service) combine(request *Request, response *Response) {
+	var combined Set
+	for i, key := range request.Keys {
+		set, err := ks.shardFor(key).store.SMembers(key)
+		if err != nil {
+			response.fail(err)
+			return
+		}
+		switch {
+		case i == 0 || request.Op == SUNION:
+			combined = combined.Union(set)
+		case request.Op == SINTER:
+			combined = combined.Intersect(set)
+		default:
+			combined = combined.Diff(set)
+		}
+	}
+	if combined == nil {
+		combined = Set{}
+	}
+	response.Value = &ValueHolder{Type: SET, Val: combined}
+	response.Success = true
+}
@@ -0,0 +1,81 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeSets will write a SET and a ZSET that have each been changed
+func writeSets(t *testing.T, ks *Sync) {
+	t.Helper()
+	if _, err := ks.SAdd("set", "a", "b", "c"); err != nil {
+		t.Fatalf("SAdd: %s", err)
+	}
+	ks.SRem("set", "b")
+	if _, err := ks.ZAdd("zset", ZMember{Member: "a", Score: 3}, ZMember{Member: "b", Score: 1.5}, ZMember{Member: "c", Score: 2}); err != nil {
+		t.Fatalf("ZAdd: %s", err)
+	}
+	ks.ZIncrBy("zset", "b", 10)
+	ks.ZRem("zset", "c")
+}
+
+// checkSets will check the sets written by writeSets have been read back
+func checkSets(t *testing.T, ks *Sync) {
+	t.Helper()
+	if members, err := ks.SMembers("set"); err != nil || !reflect.DeepEqual(members, Set{"a", "c"}) {
+		t.Errorf("SMembers = %v, %v, expected [a c]", members, err)
+	}
+	if ok, err := ks.SIsMember("set", "b"); err != nil || ok {
+		t.Errorf("SIsMember(b) = %v, %v, expected the removed member to stay removed", ok, err)
+	}
+	expected := ZSet{{Member: "a", Score: 3}, {Member: "b", Score: 11.5}}
+	if members, err := ks.ZRange("zset", 0, -1); err != nil || !reflect.DeepEqual(members, expected) {
+		t.Errorf("ZRange = %v, %v, expected %v", members, err, expected)
+	}
+	if rank, err := ks.ZRank("zset", "b"); err != nil || rank != 1 {
+		t.Errorf("ZRank(b) = %d, %v, expected 1", rank, err)
+	}
+	if val, err := ks.GetValue("zset"); err != nil || TypeOf(val) != ZSET {
+		t.Errorf("GetValue(zset) = %#v, %v, expected a ZSET", val, err)
+	}
+}
+
+func TestSetsSurviveRestart(t *testing.T) {
+	for name, format := range map[string]Format{"json": JSONFormat, "binary": BinaryFormat} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			ks := NewService(path)
+			ks.UpdateFormat(format)
+			ks.Start()
+			writeSets(t, ks.Sync)
+			<-ks.Stop()
+
+			restarted := NewService(path)
+			restarted.Start()
+			defer func() { <-restarted.Stop() }()
+			checkSets(t, restarted.Sync)
+		})
+	}
+}
+
+func TestSetsReplayedFromLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openStore(t, path)
+	s.SAdd("set", "a", "b", "c")
+	s.SRem("set", "b")
+	s.ZAdd("zset", ZMember{Member: "a", Score: 3}, ZMember{Member: "b", Score: 1.5}, ZMember{Member: "c", Score: 2})
+	s.ZIncrBy("zset", "b", 10)
+	s.ZRem("zset", "c")
+	s.logChanges("set", "zset")
+	s.Close()
+
+	// The log is replayed when the service starts without a snapshot
+	ks := NewService(path)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	checkSets(t, ks.Sync)
+}
@@ -0,0 +1,314 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The keys of the store are divided between the shards by the hash of the key.
+// Each shard holds its keys in a store of its own and applies the requests for
+// them in its own routine, so requests for keys in different shards are applied
+// in parallel while the requests for a single key are still applied one at a
+// time in the order they were received. The service hands each request to the
+// queue of its shard without waiting, so a shard that is busy never holds up the
+// requests for the others. The requests that use many keys, or none at all, are
+// applied by the service itself and any that must see every shard at the same
+// moment pause all the shards whilst they are applied
+
+// DefaultShards is the number of shards the keys are divided between, which is
+// one for each CPU
+var DefaultShards = runtime.NumCPU()
+
+// serviceOperations are applied by the service rather than the shard of the key
+// as they use many keys or none at all
+const serviceOperations = FLUSH | SCAN | TRANSACTION | MREAD | MWRITE | MDELETE | SUNION | SINTER | SDIFF | STATS | WATCH | UNWATCH | PUBLISH | SUBSCRIBE | UNSUBSCRIBE
+
+// pausingOperations are the service operations that pause every shard so that
+// the keys of all the shards can be used together
+const pausingOperations = FLUSH | SCAN | TRANSACTION | MREAD | MWRITE | MDELETE | SUNION | SINTER | SDIFF | STATS
+
+// shard applies the requests for the keys held by its store
+type shard struct {
+	service  *Service             // The service the shard belongs to
+	store    *Store               // The part of the store held by the shard
+	queue    *requestQueue        // The requests for the keys of the shard
+	pause    chan *barrier        // Receives the barrier that will hold the shard still
+	pending  map[string]bool      // The keys changed by the transaction being applied
+	waiters  map[string][]*waiter // The pop requests waiting for an item to be pushed to each list
+	ready    map[string]bool      // The lists that have changed while there are requests waiting
+	timeouts chan *waiter         // The waiting requests that have timed out
+	stopped  chan struct{}        // Closed once the shard has stopped applying requests
+	parked   bool                 // True if the response to the request will be sent once it has finished waiting
+}
+
+// barrier holds every shard still so that the service can apply a request to
+// the keys of all of them
+type barrier struct {
+	paused sync.WaitGroup // Done by each shard once it has paused
+	resume chan struct{}  // Closed once the shards can continue
+	stop   bool           // True if the shards must end rather than continue
+}
+
+// newShard creates a shard for the part of the store
+func newShard(service *Service, store *Store) *shard {
+	return &shard{
+		service:  service,
+		store:    store,
+		queue:    newRequestQueue(),
+		pause:    make(chan *barrier),
+		waiters:  make(map[string][]*waiter),
+		ready:    make(map[string]bool),
+		timeouts: make(chan *waiter),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// requestQueue holds the requests waiting to be applied by a shard. It is never
+// full so a request can always be added without waiting for the shard
+type requestQueue struct {
+	mutex    sync.Mutex    // Guards the requests
+	requests []*Request    // The requests in the order they were added
+	ready    chan struct{} // Holds a value once there are requests waiting
+}
+
+// newRequestQueue creates an empty queue
+func newRequestQueue() *requestQueue {
+	return &requestQueue{ready: make(chan struct{}, 1)}
+}
+
+// push will add the request to the end of the queue
+func (q *requestQueue) push(request *Request) {
+	q.mutex.Lock()
+	q.requests = append(q.requests, request)
+	q.mutex.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take will remove every request from the queue and return them in the order
+// they were added
+func (q *requestQueue) take() []*Request {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	requests := q.requests
+	q.requests = nil
+	return requests
+}
+
+// shardOf returns the shard for the key out of n shards
+func shardOf(key string, n int) int {
+	if n == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// UpdateShards will change the number of shards the keys are divided between.
+// Any limits on the size of the store are divided evenly between the shards so
+// each shard evicts its own keys once it reaches its share. It must be called
+// before the service is started
+func (ks *Service) UpdateShards(shards int) {
+	if shards > 0 {
+		ks.shardCount = shards
+	}
+}
+
+// shardFor returns the shard holding the key
+func (ks *Service) shardFor(key string) *shard {
+	return ks.shards[shardOf(key, len(ks.shards))]
+}
+
+// pause will stop every shard once it has applied the requests already sent to
+// it. The shards stay paused until the barrier returned is released
+func (ks *Service) pause() *barrier {
+	b := &barrier{resume: make(chan struct{})}
+	b.paused.Add(len(ks.shards))
+	for _, sh := range ks.shards {
+		sh.pause <- b
+	}
+	b.paused.Wait()
+	return b
+}
+
+// release will let the paused shards continue once any requests waiting for the
+// lists changed whilst they were paused have been answered
+func (ks *Service) release(b *barrier) {
+	for _, sh := range ks.shards {
+		sh.wakeWaiters()
+	}
+	close(b.resume)
+}
+
+// start will apply the requests sent to the shard until it is stopped
+func (sh *shard) start(sweepInterval time.Duration) {
+	go func() {
+
+		// Expired keys are removed lazily on read but any that are never read
+		// again are removed by periodically sweeping the store
+		sweeper := time.NewTicker(sweepInterval)
+		defer sweeper.Stop()
+		for {
+			select {
+			case <-sh.queue.ready:
+				for _, request := range sh.queue.take() {
+					sh.handle(request)
+				}
+			case w := <-sh.timeouts:
+				sh.timeoutWaiter(w)
+			case <-sweeper.C:
+				// Remove any keys that have expired
+				if removed := sh.store.RemoveExpired(); removed > 0 {
+					log.Printf("Removed %d expired keys", removed)
+				}
+			case b := <-sh.pause:
+				// The requests sent before the pause are applied first so the
+				// order the requests were received in is kept
+				for _, request := range sh.queue.take() {
+					sh.handle(request)
+				}
+				b.paused.Done()
+				<-b.resume
+				if b.stop {
+					close(sh.stopped)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// handle will apply the request and send the response unless the request is
+// waiting, then answer any requests waiting for the changes. The request is
+// dropped if the caller stopped waiting whilst it was queued for the shard
+func (sh *shard) handle(request *Request) {
+	if err := request.Err(); err != nil {
+		respond(request, &Response{Error: err.Error(), Code: CodeOf(err)})
+		return
+	}
+	response := &Response{}
+	sh.apply(request, response)
+	if sh.parked {
+		sh.parked = false
+	} else {
+		respond(request, response)
+	}
+	sh.wakeWaiters()
+}
+
+// apply will perform the operation for the request on the store of the shard
+func (sh *shard) apply(request *Request, response *Response) {
+
+	// A write must not take the store beyond its limits
+	if request.Op&growOperations != 0 {
+		if err := sh.makeRoom(request.Key); err != nil {
+			response.fail(err)
+			return
+		}
+	}
+	switch request.Op {
+	case READ:
+		sh.readValue(request, response)
+	case WRITE:
+		sh.writeValue(request, response)
+	case DELETE:
+		sh.deleteKey(request, response)
+	case CAS:
+		sh.compareAndSwap(request, response)
+	case COMPARE:
+		sh.compare(request, response)
+	case INCR, DECR, HINCR:
+		sh.increment(request, response)
+	case LPUSH, RPUSH:
+		sh.push(request, response)
+	case LPOP, RPOP:
+		sh.pop(request, response)
+	case LRANGE, LTRIM:
+		sh.rangeList(request, response)
+	case LLEN:
+		sh.listLength(request, response)
+	case HGET, HSET, HDEL, HKEYS, HEXISTS:
+		sh.field(request, response)
+	case PGET, PSET, PDELETE, PAPPEND:
+		sh.path(request, response)
+	case SADD, SREM, SISMEMBER, SMEMBERS:
+		sh.set(request, response)
+	case ZADD, ZREM, ZSCORE, ZRANK, ZRANGE, ZRANGEBYSCORE, ZINCRBY:
+		sh.zset(request, response)
+	default:
+		response.fail(generateError(Invalid, fmt.Sprintf("Unknown operation %d", request.Op)))
+	}
+}
+
+// changed records that the key has been written or deleted. The change is
+// written to the log and sent to any watches immediately unless it is part of a
+// transaction in which case it is only sent once the transaction has been committed
+func (sh *shard) changed(key string) {
+	if _, ok := sh.waiters[key]; ok {
+		sh.ready[key] = true
+	}
+	if sh.pending != nil {
+		sh.pending[key] = true
+		return
+	}
+	sh.store.logChanges(key)
+	atomic.AddInt64(&sh.service.writes, 1)
+	sh.service.notify(key)
+}
+
+// split will divide the keys of the store between n new stores by the hash of
+// each key. The new stores share the versions and the write ahead log of this
+// store, which still reads and writes the snapshot holding the keys of them all
+func (s *Store) split(n int) []*Store {
+	s.shards = make([]*Store, n)
+	for i := range s.shards {
+		part := NewEmptyStore()
+		part.sequence = s.sequence
+		part.wal = s.wal
+		part.compactionSize = 0
+		part.maxKeys = (s.maxKeys + n - 1) / n
+		part.maxMemory = (s.maxMemory + int64(n) - 1) / int64(n)
+		part.eviction = s.eviction
+		s.shards[i] = part
+	}
+	for key, val := range s.values {
+		part := s.shards[shardOf(key, n)]
+		part.values[key] = val
+		part.versions[key] = s.versions[key]
+		if expires, ok := s.expires[key]; ok {
+			part.expires[key] = expires
+		}
+	}
+	for _, part := range s.shards {
+		part.index = newKeyIndex(part.values)
+		part.resetUsage()
+	}
+
+	// The keys are now only held by the shards
+	s.values = make(map[string]interface{})
+	s.expires = make(map[string]time.Time)
+	s.versions = make(map[string]uint64)
+	s.index = &keyIndex{}
+	s.resetUsage()
+	return s.shards
+}
+
+// parts returns the stores holding the keys, which is the store itself unless
+// it has been split
+func (s *Store) parts() []*Store {
+	if len(s.shards) == 0 {
+		return []*Store{s}
+	}
+	return s.shards
+}
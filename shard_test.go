@@ -0,0 +1,57 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBusyShardDoesNotHoldUpOthers(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(2)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	keys := keysInShards(2)
+	ks.SetInt(keys[1], 1)
+
+	// The first shard is held still as though it were applying a slow write
+	busy := ks.shardFor(keys[0])
+	b := &barrier{resume: make(chan struct{})}
+	b.paused.Add(1)
+	busy.pause <- b
+	b.paused.Wait()
+
+	// Many writes are left waiting in the queue of the busy shard
+	writes := make([]Awaitable, 500)
+	for i := range writes {
+		var key string
+		for n := 0; key == "" || shardOf(key, 2) != shardOf(keys[0], 2); n++ {
+			key = fmt.Sprintf("busy%d.%d", i, n)
+		}
+		writes[i] = ks.SendAsync(NewWriteRequest(key, INT, i))
+	}
+
+	read := FutureValue[int](ks.SendAsync(NewReadRequest(keys[1], INT)))
+	select {
+	case <-read.Done():
+		if val, err := read.Wait(); err != nil || val != 1 {
+			t.Errorf("GetInt(%s) = %d, %v, expected 1", keys[1], val, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("The read of the other shard waited for the busy shard")
+	}
+	select {
+	case <-writes[0].Done():
+		t.Fatal("A write was applied by the busy shard whilst it was held")
+	default:
+	}
+
+	// Once the shard continues the writes are applied in order
+	close(b.resume)
+	if err := WaitAll(writes...); err != nil {
+		t.Errorf("A write to the busy shard failed: %s", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Format is the file format used when the store is saved to disk
+type Format uint
+
+// The formats available for saving the store. When the store is read from disk
+// the format is determined from the file itself
+const (
+	JSONFormat   Format = iota // A human readable json document
+	BinaryFormat               // A compact binary format with checksums
+)
+
+// snapshotVersion is the current version of the json snapshot written to disk
+const snapshotVersion = 2
+
+// snapshotMarker is the field written first to a json snapshot, holding
+// snapshotMarkerValue, so that a snapshot is never mistaken for a plain map of
+// values that happens to hold the same keys
+const (
+	snapshotMarker      = "Format"
+	snapshotMarkerValue = "keystore"
+)
+
+// snapshotBufferSize is the size of the buffer used to stream the snapshot
+const snapshotBufferSize = 64 * 1024
+
+// UpdateFormat will change the file format used when the store is saved to disk
+func (s *Store) UpdateFormat(format Format) {
+	s.format = format
+}
+
+// loadSnapshot will replace the values in the store with the snapshot at the
+// path. The store is left untouched if the snapshot is not valid
+func (s *Store) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The format is determined by the header of the file
+	r := bufio.NewReaderSize(f, snapshotBufferSize)
+	l := newLoader()
+	if header, _ := r.Peek(len(binaryMagic)); bytes.Equal(header, binaryMagic) {
+		err = readBinarySnapshot(r, l)
+	} else {
+		err = readJSONSnapshot(r, l)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Any values written before versions were recorded are given a new version
+	for key := range l.values {
+		if _, ok := l.versions[key]; !ok {
+			l.sequence++
+			l.versions[key] = l.sequence
+		}
+	}
+	s.values = l.values
+	s.expires = l.expires
+	s.versions = l.versions
+	s.sequence = &sequence{last: l.sequence}
+	s.index = newKeyIndex(l.values)
+	s.resetUsage()
+	return nil
+}
+
+// writeSnapshot will stream the values to the file using the configured format
+// and flush them to disk before closing the file
+func (s *Store) writeSnapshot(fo *os.File) error {
+	w := bufio.NewWriterSize(fo, snapshotBufferSize)
+	var err error
+	if s.format == BinaryFormat {
+		err = s.writeBinarySnapshot(w)
+	} else {
+		err = s.writeJSONSnapshot(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fo.Sync()
+	}
+	if cerr := fo.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loader holds the values as they are read from a snapshot so that the store
+// is only replaced once the whole snapshot has been read
+type loader struct {
+	values   map[string]interface{}
+	expires  map[string]time.Time
+	versions map[string]uint64
+	sequence uint64
+	now      time.Time
+}
+
+// newLoader creates an empty loader
+func newLoader() *loader {
+	return &loader{
+		values:   make(map[string]interface{}),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]uint64),
+		now:      time.Now(),
+	}
+}
+
+// add will add the value to the store being loaded unless it has expired
+func (l *loader) add(key string, val interface{}, expiry *time.Time, version uint64) {
+	if expiry != nil && !l.now.Before(*expiry) {
+		return
+	}
+	l.values[key] = val
+	if expiry != nil {
+		l.expires[key] = *expiry
+	}
+	if version > 0 {
+		l.versions[key] = version
+	}
+	l.updateSequence(version)
+}
+
+// updateSequence ensures the sequence is never behind a version that has been used
+func (l *loader) updateSequence(version uint64) {
+	if version > l.sequence {
+		l.sequence = version
+	}
+}
+
+// writeJSONSnapshot will write each of the records in turn so that the whole
+// document is never held in memory. Any write error is held by the writer
+func (s *Store) writeJSONSnapshot(w *bufio.Writer) error {
+	fmt.Fprintf(w, `{"%s":"%s","Version":%d,"Sequence":%d,"Records":{`, snapshotMarker, snapshotMarkerValue, snapshotVersion, s.sequence.current())
+	first := true
+	for _, part := range s.parts() {
+		for key := range part.values {
+			r, err := part.newRecord(key)
+			if err != nil {
+				return err
+			}
+			rb, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			kb, _ := json.Marshal(key)
+			if !first {
+				w.WriteByte(',')
+			}
+			first = false
+			w.Write(kb)
+			w.WriteByte(':')
+			w.Write(rb)
+		}
+	}
+	_, err := w.WriteString("}}")
+	return err
+}
+
+// readJSONSnapshot will decode the records from the json document one at a time.
+// Files written before expiry support are a plain map of the values and those
+// written before the types were recorded (version 1) hold the values and expiry
+// times separately. Both are migrated when the store is next saved. A plain map
+// may hold any keys so the document is only read as a snapshot when it holds the
+// snapshot marker, or for files written before the marker, only the fields of
+// the snapshot
+func readJSONSnapshot(r io.Reader, l *loader) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	top := make(map[string]json.RawMessage)
+	var version int
+	var marked bool
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+
+		// The records are streamed as the marker and version are always written first
+		if key == "Records" && marked && version > 0 {
+			if err = readJSONRecords(decoder, l); err != nil {
+				return err
+			}
+			continue
+		}
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			return err
+		}
+		switch key {
+		case "Version":
+			json.Unmarshal(raw, &version)
+		case snapshotMarker:
+			marked = string(raw) == `"`+snapshotMarkerValue+`"`
+		}
+		top[key] = raw
+	}
+	if err := expectDelim(decoder, '}'); err != nil {
+		return err
+	}
+
+	// Determine which of the formats has been read
+	switch {
+	case marked && version > snapshotVersion:
+		return generateError(Invalid, fmt.Sprintf("Unsupported snapshot version %d", version))
+	case marked || isSnapshot(top, snapshotVersion, "Records", "Sequence"):
+		if raw, ok := top["Records"]; ok {
+			if err := readJSONRecords(json.NewDecoder(bytes.NewReader(raw)), l); err != nil {
+				return err
+			}
+		}
+		if raw, ok := top["Sequence"]; ok {
+			var sequence uint64
+			if err := json.Unmarshal(raw, &sequence); err != nil {
+				return err
+			}
+			l.updateSequence(sequence)
+		}
+	case isSnapshot(top, 1, "Values", "Expires"):
+		v1Values := make(map[string]json.RawMessage)
+		v1Expires := make(map[string]time.Time)
+		if err := json.Unmarshal(top["Values"], &v1Values); err != nil {
+			return err
+		}
+		if raw, ok := top["Expires"]; ok {
+			if err := json.Unmarshal(raw, &v1Expires); err != nil {
+				return err
+			}
+		}
+		for key, raw := range v1Values {
+			val, err := (&record{Val: raw}).value()
+			if err != nil {
+				return err
+			}
+			var expiry *time.Time
+			if e, ok := v1Expires[key]; ok {
+				expiry = &e
+			}
+			l.add(key, val, expiry, 0)
+		}
+	default:
+		for key, raw := range top {
+			val, err := (&record{Val: raw}).value()
+			if err != nil {
+				return err
+			}
+			l.add(key, val, nil, 0)
+		}
+	}
+	return nil
+}
+
+// isSnapshot returns true if the document written without the snapshot marker
+// is a snapshot of the version. It must hold the version and the data field and
+// can only hold the other fields given
+func isSnapshot(top map[string]json.RawMessage, version int, data string, fields ...string) bool {
+	if string(top["Version"]) != fmt.Sprint(version) || top[data] == nil || !bytes.HasPrefix(top[data], []byte("{")) {
+		return false
+	}
+	allowed := map[string]bool{"Version": true, data: true}
+	for _, field := range fields {
+		allowed[field] = true
+	}
+	for key := range top {
+		if !allowed[key] {
+			return false
+		}
+	}
+	return true
+}
+
+// readJSONRecords will decode each of the records within the records object
+func readJSONRecords(decoder *json.Decoder, l *loader) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+		r := record{}
+		if err = decoder.Decode(&r); err != nil {
+			return err
+		}
+		val, err := r.value()
+		if err != nil {
+			return err
+		}
+		l.add(key, val, r.Expires, r.Version)
+	}
+	return expectDelim(decoder, '}')
+}
+
+// expectDelim will return an error if the next token is not the delimiter
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return generateError(Invalid, fmt.Sprintf("Expected '%s' but found '%v'", delim, token))
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// typedValues holds a value of each type
+var typedValues = map[string]interface{}{
+	"bool":   true,
+	"int":    42,
+	"float":  2.5,
+	"whole":  3.0,
+	"string": "value",
+	"array":  []interface{}{1, 2.5, "three", nil, map[string]interface{}{"four": 4, "five": 5.0}},
+	"map":    map[string]interface{}{"a": 1, "b": []interface{}{"c"}, "d": 1e21},
+	"set":    Set{"a", "b"},
+	"zset":   ZSet{{Member: "a", Score: 1}, {Member: "b", Score: 2.5}},
+}
+
+// roundTrip will save the values using the format and return the store read back
+func roundTrip(t *testing.T, format Format, values map[string]interface{}) (saved, loaded *Store) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "store")
+	saved = NewStoreFromFile(path)
+	saved.UpdateFormat(format)
+	for key, val := range values {
+		if err := saved.SetValue(key, val); err != nil {
+			t.Fatalf("SetValue(%s): %s", key, err)
+		}
+	}
+	saved.SetValueWithTTL("ttl", "expires", time.Hour)
+	if err := saved.SaveToDisk(); err != nil {
+		t.Fatalf("SaveToDisk: %s", err)
+	}
+	loaded = NewStoreFromFile(path)
+	if err := loaded.loadSnapshot(path); err != nil {
+		t.Fatalf("loadSnapshot: %s", err)
+	}
+	return saved, loaded
+}
+
+func TestJSONSnapshotKeepsTypes(t *testing.T) {
+	saved, loaded := roundTrip(t, JSONFormat, typedValues)
+	for key, val := range typedValues {
+		got, err := loaded.GetValue(key)
+		if err != nil || !reflect.DeepEqual(got, val) {
+			t.Errorf("GetValue(%s) = %#v, %v, expected %#v", key, got, err, val)
+		}
+		if loaded.Version(key) != saved.Version(key) {
+			t.Errorf("Version(%s) = %d, expected %d", key, loaded.Version(key), saved.Version(key))
+		}
+	}
+	if ttl, err := loaded.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(ttl) = %s, %v, expected the time left", ttl, err)
+	}
+}
+
+func TestReadLegacyJSONSnapshots(t *testing.T) {
+	tests := map[string]struct {
+		file     string
+		expected map[string]interface{}
+	}{
+		"plain": {
+			file:     `{"a":1,"b":"two"}`,
+			expected: map[string]interface{}{"a": 1, "b": "two"},
+		},
+		"plain with snapshot keys": {
+			file:     `{"Version":1,"Values":{"a":1},"other":true}`,
+			expected: map[string]interface{}{"Version": 1, "Values": map[string]interface{}{"a": 1}, "other": true},
+		},
+		"plain with a numeric version": {
+			file:     `{"Version":2,"Records":"none"}`,
+			expected: map[string]interface{}{"Version": 2, "Records": "none"},
+		},
+		"version 1": {
+			file:     `{"Version":1,"Values":{"a":1,"b":[1.5]}}`,
+			expected: map[string]interface{}{"a": 1, "b": []interface{}{1.5}},
+		},
+		"version 2 without the marker": {
+			file:     `{"Version":2,"Sequence":7,"Records":{"a":{"Type":4,"Val":2}}}`,
+			expected: map[string]interface{}{"a": 2.0},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store")
+			if err := os.WriteFile(path, []byte(test.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			s := NewStoreFromFile(path)
+			if err := s.loadSnapshot(path); err != nil {
+				t.Fatalf("loadSnapshot: %s", err)
+			}
+			if !reflect.DeepEqual(s.values, test.expected) {
+				t.Errorf("Loaded %#v, expected %#v", s.values, test.expected)
+			}
+		})
+	}
+}
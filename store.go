@@ -4,20 +4,69 @@
 package keystore
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 )
 
 // Store creates a new in-memory store that can be read or written to disk
 type Store struct {
-	filePath string
-	values   map[string]interface{}
+	filePath       string
+	values         map[string]interface{}
+	expires        map[string]time.Time // The expiry time for any keys that have a TTL
+	index          *keyIndex            // The keys held in order
+	versions       map[string]uint64    // The version of each key which changes on every write
+	sequence       *sequence            // The last version used for any key
+	wal            *writeAheadLog       // The log of operations since the last snapshot
+	syncPolicy     SyncPolicy           // How often the log is flushed to disk
+	compactionSize int64                // The log size that will trigger a new snapshot
+	backupCount    int                  // The number of previous snapshots that are kept
+	format         Format               // The file format used to save the store
+	usage          map[string]*keyUsage // How each key has been used so that keys can be evicted
+	clock          uint64               // Incremented on every access to order the accesses
+	memory         int64                // The approximate size of the store (only when there is a max memory)
+	maxKeys        int                  // The maximum number of keys (zero for no limit)
+	maxMemory      int64                // The maximum approximate size of the store in bytes (zero for no limit)
+	eviction       EvictionPolicy       // How keys are removed once a limit has been reached
+	evictions      uint64               // The number of keys that have been evicted
+	rejections     uint64               // The number of writes rejected because the store was full
+	shards         []*Store             // The stores holding each part of the keys once the store has been split
 }
 
+// sequence holds the last version used for any key. It is shared by the shards
+// of a store so that no version is ever used twice
+type sequence struct {
+	last uint64
+}
+
+// next returns a new version that is greater than any used before
+func (q *sequence) next() uint64 {
+	return atomic.AddUint64(&q.last, 1)
+}
+
+// current returns the last version used
+func (q *sequence) current() uint64 {
+	return atomic.LoadUint64(&q.last)
+}
+
+// advance ensures the sequence is never behind a version that has been used
+func (q *sequence) advance(version uint64) {
+	for {
+		last := atomic.LoadUint64(&q.last)
+		if version <= last || atomic.CompareAndSwapUint64(&q.last, last, version) {
+			return
+		}
+	}
+}
+
+// DefaultBackupCount is the number of previous snapshots that are kept
+const DefaultBackupCount = 3
+
 // NewEmptyStore creates a new empty Store purely in memory and backed by no store
 func NewEmptyStore() *Store {
 	return NewStoreFromFile("")
@@ -25,7 +74,18 @@ func NewEmptyStore() *Store {
 
 // NewStoreFromFile creates a new empty Store that is backed by disk
 func NewStoreFromFile(filePath string) *Store {
-	return &Store{filePath: filePath, values: make(map[string]interface{})}
+	return &Store{
+		filePath:       filePath,
+		values:         make(map[string]interface{}),
+		expires:        make(map[string]time.Time),
+		index:          &keyIndex{},
+		versions:       make(map[string]uint64),
+		sequence:       &sequence{},
+		syncPolicy:     SyncInterval,
+		compactionSize: DefaultCompactionSize,
+		backupCount:    DefaultBackupCount,
+		usage:          make(map[string]*keyUsage),
+	}
 }
 
 // UpdateFilePath will update the current file path to allow the data to be saved
@@ -34,90 +94,309 @@ func (s *Store) UpdateFilePath(filePath string) {
 	s.filePath = filePath
 }
 
+// UpdateBackupCount will change the number of previous snapshots that are kept
+func (s *Store) UpdateBackupCount(count int) {
+	s.backupCount = count
+}
+
+// ErrRecoveredFromBackup is returned by ReadFromDisk when the snapshot could not
+// be loaded and the store was recovered from a backup instead. The write ahead
+// log only holds the changes made since the snapshot so any changes made between
+// the backup and the snapshot have been lost
+var ErrRecoveredFromBackup = errors.New("The snapshot could not be loaded so the store was recovered from a backup and the changes made since the backup may have been lost")
+
 // ReadFromDisk loads the configuration from disk into this Store
-// It returns an error if the data cannot be loaded from disk
-func (s *Store) ReadFromDisk() (err error) {
+// It returns an error if the data cannot be loaded from disk. Any operations
+// in the write ahead log since the snapshot was taken are then replayed. The
+// log is replayed and opened even if no snapshot could be loaded so that the
+// operations it holds are not lost and later writes are still logged. If a
+// backup was used ErrRecoveredFromBackup is returned once the store is loaded
+func (s *Store) ReadFromDisk() error {
 
 	// If there is no file path then return
-	if s.filePath != "" {
-		log.Printf("Loading keystore from disk path %s", s.filePath)
+	if s.filePath == "" {
+		return nil
+	}
+	log.Printf("Loading keystore from disk path %s", s.filePath)
+	snapshotErr := s.readSnapshot()
+	if err := s.replayLog(); err != nil {
+		return err
+	}
+	return snapshotErr
+}
 
-		// Attempt to open the file
-		f, err := os.Open(s.filePath)
+// readSnapshot will load the values from the snapshot file. If the snapshot
+// cannot be read the newest valid backup is used instead
+func (s *Store) readSnapshot() error {
+	var firstErr error
+	for i, path := range s.snapshotPaths() {
+		err := s.loadSnapshot(path)
 		if err == nil {
-			defer f.Close()
-			var b bytes.Buffer
-			_, err = b.ReadFrom(f)
-			if err == nil {
-				err = json.Unmarshal(b.Bytes(), &s.values)
+			if i > 0 {
+				log.Printf("Recovered keystore from backup %s, any changes made after it was replaced have been lost", path)
+				return fmt.Errorf("%w (%s)", ErrRecoveredFromBackup, path)
+			}
+			return nil
+		}
+
+		// The files will not exist until the first save
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to load keystore from %s: %s", path, err)
+			if firstErr == nil {
+				firstErr = err
 			}
 		}
 	}
-	return
+	return firstErr
+}
+
+// snapshotPaths returns the snapshot file followed by each of the backups
+// from the newest to the oldest
+func (s *Store) snapshotPaths() []string {
+	paths := []string{s.filePath}
+	for i := 1; i <= s.backupCount; i++ {
+		paths = append(paths, s.backupPath(i))
+	}
+	return paths
+}
+
+// backupPath returns the path of the nth previous snapshot
+func (s *Store) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.filePath, n)
 }
 
 // SaveToDisk will flush the current to disk if their is a valid filepath
-func (s *Store) SaveToDisk() (err error) {
+// Any keys that have already expired will not be saved. The snapshot is written
+// to a temporary file that replaces the existing snapshot once it is safely on
+// disk, keeping the previous snapshots as backups. Once the snapshot has been
+// written the write ahead log is emptied
+func (s *Store) SaveToDisk() error {
 
 	// If there is no file path then return
-	if s.filePath != "" {
-		log.Printf("Saving keystore to disk path %s", s.filePath)
+	if s.filePath == "" {
+		return nil
+	}
+	log.Printf("Saving keystore to disk path %s", s.filePath)
 
-		// Marshall this to disk
-		b, err := json.Marshal(s.values)
-		if err == nil {
+	// The temporary file must be in the same directory for the rename to be atomic
+	for _, part := range s.parts() {
+		part.RemoveExpired()
+	}
+	dir, name := filepath.Split(s.filePath)
+	fo, err := os.CreateTemp(dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
 
-			// Get access to the file
-			fo, err := os.Create(s.filePath)
-			defer fo.Close()
-			if err == nil {
+	// Stream the values to the file
+	if err = s.writeSnapshot(fo); err != nil {
+		os.Remove(fo.Name())
+		return err
+	}
 
-				// Write the bytes to the buffer
-				var buffer bytes.Buffer
-				_, err = buffer.Write(b)
-				if err == nil {
+	// Keep the current snapshot as a backup and then replace it
+	s.rotateBackups()
+	if err = os.Rename(fo.Name(), s.filePath); err != nil {
+		os.Remove(fo.Name())
+		return err
+	}
+	syncDir(dir)
 
-					// Write the buffer to disk
-					_, err = buffer.WriteTo(fo)
-				}
-			}
+	// The snapshot must be on disk before the log can be emptied
+	return s.truncateLog()
+}
+
+// rotateBackups will shift each of the backups along, dropping the oldest, and
+// keep the current snapshot as the newest backup
+func (s *Store) rotateBackups() {
+	if s.backupCount <= 0 {
+		return
+	}
+	for i := s.backupCount; i > 1; i-- {
+		if err := os.Rename(s.backupPath(i-1), s.backupPath(i)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error rotating backup %s: %s", s.backupPath(i-1), err)
+		}
+	}
+
+	// The current snapshot is never moved so there is always a snapshot on disk.
+	// It is linked, or copied if the file system does not support links, after
+	// removing the newest backup which is still there when only one is kept
+	backup := s.backupPath(1)
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing backup %s: %s", backup, err)
+	}
+	if err := os.Link(s.filePath, backup); err != nil && !os.IsNotExist(err) {
+		if err = copyFile(s.filePath, backup); err != nil {
+			log.Printf("Error creating backup %s: %s", backup, err)
 		}
 	}
-	return
 }
 
-// generateError will return a new error containing the message
-func generateError(message string) error {
-	return errors.New(message)
+// copyFile will copy the contents of the file at src to a new file at dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// syncDir will flush the directory entry to disk so a rename is durable.
+// Not every platform supports this so any error is ignored
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 }
 
 // generateTypeError will return an error indicating that the value type for the key is incorrect
 func generateTypeError(key string) error {
-	return generateError(fmt.Sprintf("The value for key '%s' is not of the correct type", key))
+	return generateError(WrongType, fmt.Sprintf("The value for key '%s' is not of the correct type", key))
+}
+
+// generateNotExistError will return an error indicating that the key does not exist
+func generateNotExistError(key string) error {
+	return generateError(NotFound, fmt.Sprintf("The key '%s' specified does not exist", key))
 }
 
 // KeyExists returns true if the key exists in the store
 func (s *Store) KeyExists(key string) (exists bool) {
+	s.expireKey(key)
 	_, exists = s.values[key]
 	return
 }
 
 // DeleteKey will delete the key from the store
 func (s *Store) DeleteKey(key string) {
-	delete(s.values, key)
+	if _, exists := s.values[key]; exists {
+		delete(s.values, key)
+		delete(s.expires, key)
+		delete(s.versions, key)
+		s.index.remove(key)
+		s.forget(key)
+		s.sequence.next()
+	}
+}
+
+// keyState holds everything stored for a key so that it can be restored
+type keyState struct {
+	exists  bool
+	val     interface{}
+	expires *time.Time
+	version uint64
+}
+
+// state returns everything currently stored for the key
+func (s *Store) state(key string) *keyState {
+	st := &keyState{}
+	if s.KeyExists(key) {
+		st.exists = true
+		st.val = s.values[key]
+		st.version = s.versions[key]
+		if expires, ok := s.expires[key]; ok {
+			st.expires = &expires
+		}
+	}
+	return st
+}
+
+// restore will return the key to the state provided
+func (s *Store) restore(key string, st *keyState) {
+	if !st.exists {
+		s.DeleteKey(key)
+		return
+	}
+	s.SetValue(key, st.val)
+	s.versions[key] = st.version
+	if st.expires != nil {
+		s.expires[key] = *st.expires
+	}
+}
+
+// Version returns the current version of the key or zero if it does not exist.
+// Each write to a key will give it a new version that is greater than any
+// version previously used in the store
+func (s *Store) Version(key string) uint64 {
+	s.expireKey(key)
+	return s.versions[key]
+}
+
+// TTL returns the time remaining before the key expires. If the key has no
+// expiry zero is returned
+func (s *Store) TTL(key string) (time.Duration, error) {
+	if !s.KeyExists(key) {
+		return 0, generateNotExistError(key)
+	}
+	if expires, ok := s.expires[key]; ok {
+		return time.Until(expires), nil
+	}
+	return 0, nil
+}
+
+// RemoveExpired will delete all the keys that have expired returning the
+// number of keys that were removed
+func (s *Store) RemoveExpired() (removed int) {
+	now := time.Now()
+	for key, expires := range s.expires {
+		if !now.Before(expires) {
+			s.DeleteKey(key)
+			removed++
+		}
+	}
+	return
+}
+
+// expireKey will delete the key if it has expired
+func (s *Store) expireKey(key string) {
+	if expires, ok := s.expires[key]; ok && !time.Now().Before(expires) {
+		s.DeleteKey(key)
+	}
 }
 
 // GetValue implements KeyValueStore interface
 func (s *Store) GetValue(key string) (val interface{}, err error) {
-	val, exists := s.values[key]
+	val, exists := s.access(key)
 
 	// Check that the key exists and return an error if not
 	if !exists {
-		err = generateError(fmt.Sprintf("The key '%s' specified does not exist", key))
+		err = generateNotExistError(key)
+	}
+
+	// A list may be pushed to in place so the slice returned is limited to its
+	// length to ensure any append by the caller will copy it
+	if list, ok := val.([]interface{}); ok {
+		val = list[:len(list):len(list)]
 	}
 	return
 }
 
+// GetValueType implements KeyValueStore interface
+func (s *Store) GetValueType(key string, v interface{}) error {
+	val, err := s.GetValue(key)
+	if err != nil {
+		return err
+	}
+	return Decode(val, v)
+}
+
 // GetBool implements KeyValueStore interface
 func (s *Store) GetBool(key string) (interface{}, error) {
 	// Get the value and then attempt to type assert
@@ -208,55 +487,175 @@ func (s *Store) GetMap(key string) (interface{}, error) {
 	return nil, err
 }
 
+// GetSet implements KeyValueStore interface
+func (s *Store) GetSet(key string) (interface{}, error) {
+	// Get the value and then attempt to type assert
+	raw, err := s.GetValue(key)
+	if err == nil {
+		// Type assertion
+		val, ok := raw.(Set)
+		if !ok {
+			err = generateTypeError(key)
+		}
+		return val, err
+	}
+	return nil, err
+}
+
+// GetZSet implements KeyValueStore interface
+func (s *Store) GetZSet(key string) (interface{}, error) {
+	// Get the value and then attempt to type assert
+	raw, err := s.GetValue(key)
+	if err == nil {
+		// Type assertion
+		val, ok := raw.(ZSet)
+		if !ok {
+			err = generateTypeError(key)
+		}
+		return val, err
+	}
+	return nil, err
+}
+
 // SetValue implements KeyValueStore interface
 func (s *Store) SetValue(key string, value interface{}) error {
-	s.values[key] = value
-	return nil
+	return s.SetValueWithTTL(key, value, 0)
 }
 
 // SetBool implements KeyValueStore interface
 func (s *Store) SetBool(key string, value interface{}) error {
-	val, ok := value.(bool)
-	return s.setValueOrReturnError(key, val, ok)
+	return s.SetBoolWithTTL(key, value, 0)
 }
 
 // SetInt implements KeyValueStore interface
 func (s *Store) SetInt(key string, value interface{}) error {
-	val, ok := value.(int)
-	return s.setValueOrReturnError(key, val, ok)
+	return s.SetIntWithTTL(key, value, 0)
 }
 
 // SetFloat implements KeyValueStore interface
 func (s *Store) SetFloat(key string, value interface{}) error {
-	val, ok := value.(float64)
-	return s.setValueOrReturnError(key, val, ok)
+	return s.SetFloatWithTTL(key, value, 0)
 }
 
 // SetString implements KeyValueStore interface
 func (s *Store) SetString(key string, value interface{}) error {
-	val, ok := value.(string)
-	return s.setValueOrReturnError(key, val, ok)
+	return s.SetStringWithTTL(key, value, 0)
 }
 
 // SetArray implements KeyValueStore interface
 func (s *Store) SetArray(key string, value interface{}) error {
-	val, ok := value.([]interface{})
-	return s.setValueOrReturnError(key, val, ok)
+	return s.SetArrayWithTTL(key, value, 0)
 }
 
 // SetMap implements KeyValueStore interface
 func (s *Store) SetMap(key string, value interface{}) error {
+	return s.SetMapWithTTL(key, value, 0)
+}
+
+// SetSet implements KeyValueStore interface
+func (s *Store) SetSet(key string, value interface{}) error {
+	return s.SetSetWithTTL(key, value, 0)
+}
+
+// SetZSet implements KeyValueStore interface
+func (s *Store) SetZSet(key string, value interface{}) error {
+	return s.SetZSetWithTTL(key, value, 0)
+}
+
+// SetValueWithTTL implements KeyValueStore interface
+func (s *Store) SetValueWithTTL(key string, value interface{}, ttl time.Duration) error {
+
+	// The value is held in its canonical form so that it is read back the same
+	// through every client and transport
+	value, err := Normalize(value)
+	if err != nil {
+		return err
+	}
+
+	// The members of the sets must be kept in order
+	switch v := value.(type) {
+	case Set:
+		value = NewSet(v...)
+	case ZSet:
+		value = NewZSet(v...)
+	}
+	s.updateValue(key, value)
+	if ttl > 0 {
+		s.expires[key] = time.Now().Add(ttl)
+	} else {
+		delete(s.expires, key)
+	}
+	return nil
+}
+
+// SetBoolWithTTL implements KeyValueStore interface
+func (s *Store) SetBoolWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := value.(bool)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetIntWithTTL implements KeyValueStore interface
+func (s *Store) SetIntWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := value.(int)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetFloatWithTTL implements KeyValueStore interface
+func (s *Store) SetFloatWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := value.(float64)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetStringWithTTL implements KeyValueStore interface
+func (s *Store) SetStringWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := value.(string)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetArrayWithTTL implements KeyValueStore interface
+func (s *Store) SetArrayWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := value.([]interface{})
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetMapWithTTL implements KeyValueStore interface
+func (s *Store) SetMapWithTTL(key string, value interface{}, ttl time.Duration) error {
 	val, ok := value.(map[string]interface{})
-	return s.setValueOrReturnError(key, val, ok)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetSetWithTTL implements KeyValueStore interface. The members may also be
+// given as a slice of strings
+func (s *Store) SetSetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := toSet(value)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// SetZSetWithTTL implements KeyValueStore interface. The members may also be
+// given as a map of each member to its score
+func (s *Store) SetZSetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	val, ok := toZSet(value)
+	return s.setValueOrReturnError(key, val, ok, ttl)
+}
+
+// updateValue will store the value for the key giving it a new version. Any
+// expiry time for the key is kept
+func (s *Store) updateValue(key string, value interface{}) {
+	if _, exists := s.values[key]; !exists {
+		s.index.insert(key)
+	}
+	s.values[key] = value
+	s.versions[key] = s.sequence.next()
+	s.track(key, value)
 }
 
 // setValueOrReturnError expects the value and whether the type assertion is ok.
 // If the assertion is !ok an error is returned and the value is not set
-func (s *Store) setValueOrReturnError(key string, val interface{}, ok bool) (err error) {
+func (s *Store) setValueOrReturnError(key string, val interface{}, ok bool, ttl time.Duration) (err error) {
 	if !ok {
 		err = generateTypeError(key)
 	} else {
-		s.SetValue(key, val)
+		err = s.SetValueWithTTL(key, val, ttl)
 	}
 	return
 }
@@ -0,0 +1,81 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// saveValue will write the value of the key to a new snapshot
+func saveValue(t *testing.T, s *Store, val interface{}) {
+	t.Helper()
+	s.SetValue("key", val)
+	if err := s.SaveToDisk(); err != nil {
+		t.Fatalf("SaveToDisk: %s", err)
+	}
+}
+
+func TestSaveToDiskRotatesBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := NewStoreFromFile(path)
+	s.UpdateBackupCount(2)
+	for i := 1; i <= 4; i++ {
+		saveValue(t, s, i)
+	}
+
+	// The newest backup is the snapshot that was replaced last
+	for n, expected := range map[string]int{path: 4, path + ".1": 3, path + ".2": 2} {
+		loaded := NewEmptyStore()
+		if err := loaded.loadSnapshot(n); err != nil {
+			t.Errorf("Unable to load %s: %s", n, err)
+		} else if val, _ := loaded.GetValue("key"); val != expected {
+			t.Errorf("%s holds %v, expected %d", n, val, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("More backups were kept than the backup count: %v", err)
+	}
+}
+
+func TestSaveToDiskWithOneBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := NewStoreFromFile(path)
+	s.UpdateBackupCount(1)
+	saveValue(t, s, 1)
+	saveValue(t, s, 2)
+
+	// The snapshot is kept in place while the newest backup is replaced
+	s.rotateBackups()
+	for n, expected := range map[string]int{path: 2, path + ".1": 2} {
+		loaded := NewEmptyStore()
+		if err := loaded.loadSnapshot(n); err != nil {
+			t.Fatalf("Unable to load %s: %s", n, err)
+		} else if val, _ := loaded.GetValue("key"); val != expected {
+			t.Errorf("%s holds %v, expected %d", n, val, expected)
+		}
+	}
+}
+
+func TestReadFromDiskRecoversFromBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := NewStoreFromFile(path)
+	saveValue(t, s, "backup")
+	saveValue(t, s, "snapshot")
+	if err := os.WriteFile(path, []byte(`{"key":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The store is loaded from the backup but the loss of any changes is reported
+	loaded := NewStoreFromFile(path)
+	defer loaded.Close()
+	if err := loaded.ReadFromDisk(); !errors.Is(err, ErrRecoveredFromBackup) {
+		t.Errorf("ReadFromDisk returned %v, expected the recovery to be reported", err)
+	}
+	if val, err := loaded.GetValue("key"); err != nil || val != "backup" {
+		t.Errorf("GetValue(key) = %v, %v, expected the value from the backup", val, err)
+	}
+}
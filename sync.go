@@ -4,8 +4,8 @@
 package keystore
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
+	"time"
 )
 
 // Sync implements the KeyValueStore and provides a synchronous blocking API
@@ -16,117 +16,1064 @@ type Sync struct {
 	RequestChannel chan *Request
 }
 
+// waitForResponse will block until the response has arrived. The response is
+// returned along with any error that it contains
+func waitForResponse(requestChannel chan *Request, request *Request) (*Response, error) {
+	return waitForResponseContext(context.Background(), requestChannel, request)
+}
+
+// waitForResponseContext will block until the response has arrived or the
+// context is done. The request carries the deadline of the context so that it
+// is dropped rather than applied once the caller has stopped waiting
+func waitForResponseContext(ctx context.Context, requestChannel chan *Request, request *Request) (*Response, error) {
+	request.Done = ctx.Done()
+	request.Deadline, _ = ctx.Deadline()
+	select {
+	case requestChannel <- request:
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+	select {
+	case response := <-request.ResponseChannel:
+		return response, response.Err()
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+}
+
 // waitForReadValue will block until the value has arrived
 func waitForReadValue(requestChannel chan *Request, request *Request) (interface{}, error) {
-	requestChannel <- request
-	response := <-request.ResponseChannel
-	if response.Error != "" {
-		return nil, errors.New(response.Error)
+	return waitForReadValueContext(context.Background(), requestChannel, request)
+}
+
+// waitForReadValueContext will block until the value has arrived or the context is done
+func waitForReadValueContext(ctx context.Context, requestChannel chan *Request, request *Request) (interface{}, error) {
+	response, err := waitForResponseContext(ctx, requestChannel, request)
+	if err != nil {
+		return nil, err
+	}
+	return response.value(), nil
+}
+
+// value returns the value held by the response or nil if there is no response
+// or it does not hold a value
+func (response *Response) value() interface{} {
+	if response == nil || response.Value == nil {
+		return nil
 	}
-	return response.Value.Val, nil
+	return response.Value.Val
+}
+
+// waitForWriteValue will block until the response has arrived
+func waitForWriteValue(requestChannel chan *Request, request *Request) error {
+	_, err := waitForResponse(requestChannel, request)
+	return err
+}
+
+// waitForWriteValueContext will block until the response has arrived or the context is done
+func waitForWriteValueContext(ctx context.Context, requestChannel chan *Request, request *Request) error {
+	_, err := waitForResponseContext(ctx, requestChannel, request)
+	return err
 }
 
 // GetValueType implements KeyValueStore
 func (s *Sync) GetValueType(key string, v interface{}) error {
+	return s.GetValueTypeContext(context.Background(), key, v)
+}
 
-	// Using this impl the set value converts the item to a json string
-	raw, err := s.GetString(key)
+// GetValueTypeContext implements ContextKeyValueStore
+func (s *Sync) GetValueTypeContext(ctx context.Context, key string, v interface{}) error {
+	raw, err := s.GetValueContext(ctx, key)
 	if err != nil {
 		return err
 	}
 
 	// Attempt to parse into the expected interface type
-	return json.Unmarshal([]byte(raw.(string)), v)
+	return Decode(raw, v)
 }
 
 // GetValue implements KeyValueStore
 func (s *Sync) GetValue(key string) (interface{}, error) {
+	return s.GetValueContext(context.Background(), key)
+}
 
-	// Using this impl the set value converts the item to a json string
-	return s.GetString(key)
+// GetValueContext implements ContextKeyValueStore
+func (s *Sync) GetValueContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, NONE))
 }
 
 // GetBool implements KeyValueStore
 func (s *Sync) GetBool(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, BOOL))
+	return s.GetBoolContext(context.Background(), key)
+}
+
+// GetBoolContext implements ContextKeyValueStore
+func (s *Sync) GetBoolContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, BOOL))
 }
 
 // GetInt implements KeyValueStore
 func (s *Sync) GetInt(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, INT))
+	return s.GetIntContext(context.Background(), key)
+}
+
+// GetIntContext implements ContextKeyValueStore
+func (s *Sync) GetIntContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, INT))
 }
 
 // GetFloat implements KeyValueStore
 func (s *Sync) GetFloat(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, FLOAT))
+	return s.GetFloatContext(context.Background(), key)
+}
+
+// GetFloatContext implements ContextKeyValueStore
+func (s *Sync) GetFloatContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, FLOAT))
 }
 
 // GetString implements KeyValueStore
 func (s *Sync) GetString(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, STRING))
+	return s.GetStringContext(context.Background(), key)
+}
+
+// GetStringContext implements ContextKeyValueStore
+func (s *Sync) GetStringContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, STRING))
 }
 
 // GetArray implements KeyValueStore
 func (s *Sync) GetArray(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, ARRAY))
+	return s.GetArrayContext(context.Background(), key)
+}
+
+// GetArrayContext implements ContextKeyValueStore
+func (s *Sync) GetArrayContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, ARRAY))
 }
 
 // GetMap implements KeyValueStore
 func (s *Sync) GetMap(key string) (interface{}, error) {
-	return waitForReadValue(s.RequestChannel, NewReadRequest(key, MAP))
+	return s.GetMapContext(context.Background(), key)
 }
 
-// waitForWriteValue will block until the response has arrived
-func waitForWriteValue(requestChannel chan *Request, request *Request) error {
-	requestChannel <- request
-	response := <-request.ResponseChannel
-	if response.Error != "" {
-		return errors.New(response.Error)
-	}
-	return nil
+// GetMapContext implements ContextKeyValueStore
+func (s *Sync) GetMapContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, MAP))
+}
+
+// GetSet implements KeyValueStore
+func (s *Sync) GetSet(key string) (interface{}, error) {
+	return s.GetSetContext(context.Background(), key)
+}
+
+// GetSetContext implements ContextKeyValueStore
+func (s *Sync) GetSetContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, SET))
+}
+
+// GetZSet implements KeyValueStore
+func (s *Sync) GetZSet(key string) (interface{}, error) {
+	return s.GetZSetContext(context.Background(), key)
+}
+
+// GetZSetContext implements ContextKeyValueStore
+func (s *Sync) GetZSetContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewReadRequest(key, ZSET))
 }
 
 // SetValue implements KeyValueStore
 func (s *Sync) SetValue(key string, value interface{}) error {
+	return s.SetValueWithTTLContext(context.Background(), key, value, 0)
+}
 
-	// So as to not have to register structs with gob and to handle arbitrary data
-	// we will marhsall this to json (again, as mentioned in the servers/clients
-	// I would usually use some intermediate protocol buffers)
-	jsonb, _ := json.Marshal(value)
-	return s.SetString(key, string(jsonb))
+// SetValueContext implements ContextKeyValueStore
+func (s *Sync) SetValueContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetValueWithTTLContext(ctx, key, value, 0)
 }
 
 // SetBool implements KeyValueStore
 func (s *Sync) SetBool(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, BOOL, value))
+	return s.SetBoolWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetBoolContext implements ContextKeyValueStore
+func (s *Sync) SetBoolContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetBoolWithTTLContext(ctx, key, value, 0)
 }
 
 // SetInt implements KeyValueStore
 func (s *Sync) SetInt(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, INT, value))
+	return s.SetIntWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetIntContext implements ContextKeyValueStore
+func (s *Sync) SetIntContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetIntWithTTLContext(ctx, key, value, 0)
 }
 
 // SetFloat implements KeyValueStore
 func (s *Sync) SetFloat(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, FLOAT, value))
+	return s.SetFloatWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetFloatContext implements ContextKeyValueStore
+func (s *Sync) SetFloatContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetFloatWithTTLContext(ctx, key, value, 0)
 }
 
 // SetString implements KeyValueStore
 func (s *Sync) SetString(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, STRING, value))
+	return s.SetStringWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetStringContext implements ContextKeyValueStore
+func (s *Sync) SetStringContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetStringWithTTLContext(ctx, key, value, 0)
 }
 
 // SetArray implements KeyValueStore
 func (s *Sync) SetArray(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, ARRAY, value))
+	return s.SetArrayWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetArrayContext implements ContextKeyValueStore
+func (s *Sync) SetArrayContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetArrayWithTTLContext(ctx, key, value, 0)
 }
 
 // SetMap implements KeyValueStore
 func (s *Sync) SetMap(key string, value interface{}) error {
-	return waitForWriteValue(s.RequestChannel, NewWriteRequest(key, MAP, value))
+	return s.SetMapWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetMapContext implements ContextKeyValueStore
+func (s *Sync) SetMapContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetMapWithTTLContext(ctx, key, value, 0)
+}
+
+// SetSet implements KeyValueStore
+func (s *Sync) SetSet(key string, value interface{}) error {
+	return s.SetSetWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetSetContext implements ContextKeyValueStore
+func (s *Sync) SetSetContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetSetWithTTLContext(ctx, key, value, 0)
+}
+
+// SetZSet implements KeyValueStore
+func (s *Sync) SetZSet(key string, value interface{}) error {
+	return s.SetZSetWithTTLContext(context.Background(), key, value, 0)
+}
+
+// SetZSetContext implements ContextKeyValueStore
+func (s *Sync) SetZSetContext(ctx context.Context, key string, value interface{}) error {
+	return s.SetZSetWithTTLContext(ctx, key, value, 0)
+}
+
+// SetValueWithTTL implements KeyValueStore
+func (s *Sync) SetValueWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetValueWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetValueWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetValueWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+
+	// So as to not have to register structs with gob and to handle arbitrary data
+	// the value is converted to its canonical form before it is sent, which is
+	// the same form the store would hold it in
+	val, err := Normalize(value)
+	if err != nil {
+		return err
+	}
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, NONE, val, ttl))
+}
+
+// SetBoolWithTTL implements KeyValueStore
+func (s *Sync) SetBoolWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetBoolWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetBoolWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetBoolWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, BOOL, value, ttl))
+}
+
+// SetIntWithTTL implements KeyValueStore
+func (s *Sync) SetIntWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetIntWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetIntWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetIntWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, INT, value, ttl))
+}
+
+// SetFloatWithTTL implements KeyValueStore
+func (s *Sync) SetFloatWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetFloatWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetFloatWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetFloatWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, FLOAT, value, ttl))
+}
+
+// SetStringWithTTL implements KeyValueStore
+func (s *Sync) SetStringWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetStringWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetStringWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetStringWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, STRING, value, ttl))
+}
+
+// SetArrayWithTTL implements KeyValueStore
+func (s *Sync) SetArrayWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetArrayWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetArrayWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetArrayWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, ARRAY, value, ttl))
+}
+
+// SetMapWithTTL implements KeyValueStore
+func (s *Sync) SetMapWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetMapWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetMapWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetMapWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, MAP, value, ttl))
+}
+
+// SetSetWithTTL implements KeyValueStore
+func (s *Sync) SetSetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetSetWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetSetWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetSetWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+
+	// The set is converted before it is sent so that only the registered types
+	// are encoded by the clients
+	if set, ok := toSet(value); ok {
+		value = set
+	}
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, SET, value, ttl))
+}
+
+// SetZSetWithTTL implements KeyValueStore
+func (s *Sync) SetZSetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.SetZSetWithTTLContext(context.Background(), key, value, ttl)
+}
+
+// SetZSetWithTTLContext implements ContextKeyValueStore
+func (s *Sync) SetZSetWithTTLContext(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if zset, ok := toZSet(value); ok {
+		value = zset
+	}
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewWriteRequestWithTTL(key, ZSET, value, ttl))
 }
 
 // DeleteKey implements KeyValueStore
 func (s *Sync) DeleteKey(key string) {
-	waitForWriteValue(s.RequestChannel, NewDeleteRequest(key))
+	s.DeleteKeyContext(context.Background(), key)
+}
+
+// DeleteKeyContext implements ContextKeyValueStore
+func (s *Sync) DeleteKeyContext(ctx context.Context, key string) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewDeleteRequest(key))
+}
+
+// Flush will request that the store is written to disk
+func (s *Sync) Flush() error {
+	return waitForWriteValue(s.RequestChannel, NewFlushRequest())
+}
+
+// Scan will return the keys in order that match the options along with the
+// cursor to request the next page. The cursor is empty once there are no more keys
+func (s *Sync) Scan(options ScanOptions) ([]string, string, error) {
+	return s.ScanContext(context.Background(), options)
+}
+
+// ScanContext is the same as Scan but stops waiting once the context is done
+func (s *Sync) ScanContext(ctx context.Context, options ScanOptions) ([]string, string, error) {
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewScanRequest(options))
+	if err != nil {
+		return nil, "", err
+	}
+	return response.Keys, response.Cursor, nil
+}
+
+// Stats returns the size and limits of the store along with the number of keys
+// that have been evicted
+func (s *Sync) Stats() (*Stats, error) {
+	response, err := waitForResponse(s.RequestChannel, NewStatsRequest())
+	if err != nil {
+		return nil, err
+	}
+	return response.Stats, nil
+}
+
+// Watch will return the id of a new watch and the channel that receives an event
+// for every change to the keys matching the options. The channel is closed once
+// the watch has ended, either using Unwatch or because the events were not read
+// quickly enough
+func (s *Sync) Watch(options WatchOptions) (uint64, <-chan *Event, error) {
+	events := make(chan *Event, DefaultWatchBuffer)
+	response, err := waitForResponse(s.RequestChannel, NewWatchRequest(options, events))
+	if err != nil {
+		return 0, nil, err
+	}
+	return response.Subscription, events, nil
+}
+
+// Unwatch will end the watch and close its channel
+func (s *Sync) Unwatch(id uint64) error {
+	return waitForWriteValue(s.RequestChannel, NewUnwatchRequest(id))
+}
+
+// Publish will send the value to the subscribers of the topic returning the
+// number of subscriptions that received it. The value is not stored
+func (s *Sync) Publish(topic string, value interface{}) (int, error) {
+	value, err := Normalize(value)
+	if err != nil {
+		return 0, err
+	}
+	val, err := waitForReadValue(s.RequestChannel, NewPublishRequest(topic, NONE, value))
+	received, _ := toInt(val)
+	return received, err
+}
+
+// Subscribe will return the id of a new subscription and the channel that receives
+// the messages published to any topic matching one of the patterns. The channel is
+// closed once the subscription has ended, either using Unsubscribe or because the
+// messages were not read quickly enough
+func (s *Sync) Subscribe(patterns ...string) (uint64, <-chan *Message, error) {
+	messages := make(chan *Message, DefaultSubscribeBuffer)
+	response, err := waitForResponse(s.RequestChannel, NewSubscribeRequest(messages, patterns...))
+	if err != nil {
+		return 0, nil, err
+	}
+	return response.Subscription, messages, nil
+}
+
+// Unsubscribe will end the subscription and close its channel
+func (s *Sync) Unsubscribe(id uint64) error {
+	return waitForWriteValue(s.RequestChannel, NewUnsubscribeRequest(id))
+}
+
+// GetWithVersion returns the value of the type specified for the key along
+// with its current version
+func (s *Sync) GetWithVersion(key string, dType Type) (interface{}, uint64, error) {
+	return s.GetWithVersionContext(context.Background(), key, dType)
+}
+
+// GetWithVersionContext is the same as GetWithVersion but stops waiting once the context is done
+func (s *Sync) GetWithVersionContext(ctx context.Context, key string, dType Type) (interface{}, uint64, error) {
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewReadRequest(key, dType))
+	if err != nil {
+		return nil, 0, err
+	}
+	return response.value(), response.Version, nil
+}
+
+// CompareAndSwap will store the value of the type specified for the key only if
+// the current version of the key matches the version provided. The new version is
+// returned or ErrVersionConflict (with the current version) if it did not match
+func (s *Sync) CompareAndSwap(key string, dType Type, value interface{}, version uint64) (uint64, error) {
+	return s.CompareAndSwapContext(context.Background(), key, dType, value, version)
+}
+
+// CompareAndSwapContext is the same as CompareAndSwap but stops waiting once the context is done
+func (s *Sync) CompareAndSwapContext(ctx context.Context, key string, dType Type, value interface{}, version uint64) (uint64, error) {
+	value, err := Normalize(value)
+	if err != nil {
+		return 0, err
+	}
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewCompareAndSwapRequest(key, dType, value, version))
+	if response == nil {
+		return 0, err
+	}
+	return response.Version, err
+}
+
+// Transaction will apply the steps atomically returning the result of each step.
+// If any step fails none of the changes are applied and an error is returned
+// along with the results up to and including the step that failed
+func (s *Sync) Transaction(steps ...*Step) ([]*Response, error) {
+	return s.TransactionContext(context.Background(), steps...)
+}
+
+// TransactionContext is the same as Transaction but stops waiting once the context is done
+func (s *Sync) TransactionContext(ctx context.Context, steps ...*Step) ([]*Response, error) {
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewTransactionRequest(steps...))
+	if response == nil {
+		return nil, err
+	}
+	return response.Results, err
+}
+
+// MGet returns the values of the type specified for each of the keys using a
+// single request. Any keys that do not exist or hold a value of a different type
+// are not included
+func (s *Sync) MGet(dType Type, keys ...string) (map[string]interface{}, error) {
+	return s.MGetContext(context.Background(), dType, keys...)
+}
+
+// MGetContext is the same as MGet but stops waiting once the context is done
+func (s *Sync) MGetContext(ctx context.Context, dType Type, keys ...string) (map[string]interface{}, error) {
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewBatchReadRequest(dType, keys...))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(keys))
+	for i, result := range response.Results {
+		if result != nil && result.Success && i < len(keys) {
+			values[keys[i]] = result.value()
+		}
+	}
+	return values, nil
+}
+
+// MSet will store each of the values of the type specified using a single request.
+// If any of the values cannot be stored the first error is returned although the
+// other values will still have been stored
+func (s *Sync) MSet(dType Type, values map[string]interface{}) error {
+	return s.MSetContext(context.Background(), dType, values)
+}
+
+// MSetContext is the same as MSet but stops waiting once the context is done
+func (s *Sync) MSetContext(ctx context.Context, dType Type, values map[string]interface{}) error {
+	normalized := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		var err error
+		if normalized[key], err = Normalize(value); err != nil {
+			return err
+		}
+	}
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewBatchWriteRequest(dType, normalized))
+	if err != nil {
+		return err
+	}
+	for _, result := range response.Results {
+		if err := result.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MDelete will delete each of the keys using a single request
+func (s *Sync) MDelete(keys ...string) error {
+	return s.MDeleteContext(context.Background(), keys...)
+}
+
+// MDeleteContext is the same as MDelete but stops waiting once the context is done
+func (s *Sync) MDeleteContext(ctx context.Context, keys ...string) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewBatchDeleteRequest(keys...))
+}
+
+// IncrInt will atomically add the delta to the INT value of the key and return
+// the new value. A key that does not exist is created with a value of zero first
+func (s *Sync) IncrInt(key string, delta int) (int, error) {
+	return s.IncrIntContext(context.Background(), key, delta)
+}
+
+// IncrIntContext is the same as IncrInt but stops waiting once the context is done
+func (s *Sync) IncrIntContext(ctx context.Context, key string, delta int) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewIncrementRequest(key, INT, delta))
+	if err != nil {
+		return 0, err
+	}
+	i, _ := toInt(val)
+	return i, nil
+}
+
+// DecrInt will atomically subtract the delta from the INT value of the key and
+// return the new value. A key that does not exist is created with a value of zero first
+func (s *Sync) DecrInt(key string, delta int) (int, error) {
+	return s.DecrIntContext(context.Background(), key, delta)
+}
+
+// DecrIntContext is the same as DecrInt but stops waiting once the context is done
+func (s *Sync) DecrIntContext(ctx context.Context, key string, delta int) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewDecrementRequest(key, INT, delta))
+	if err != nil {
+		return 0, err
+	}
+	i, _ := toInt(val)
+	return i, nil
+}
+
+// IncrFloat will atomically add the delta to the FLOAT value of the key and return
+// the new value. A key that does not exist is created with a value of zero first
+func (s *Sync) IncrFloat(key string, delta float64) (float64, error) {
+	return s.IncrFloatContext(context.Background(), key, delta)
+}
+
+// IncrFloatContext is the same as IncrFloat but stops waiting once the context is done
+func (s *Sync) IncrFloatContext(ctx context.Context, key string, delta float64) (float64, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewIncrementRequest(key, FLOAT, delta))
+	if err != nil {
+		return 0, err
+	}
+	f, _ := toFloat(val)
+	return f, nil
+}
+
+// DecrFloat will atomically subtract the delta from the FLOAT value of the key and
+// return the new value. A key that does not exist is created with a value of zero first
+func (s *Sync) DecrFloat(key string, delta float64) (float64, error) {
+	return s.DecrFloatContext(context.Background(), key, delta)
+}
+
+// DecrFloatContext is the same as DecrFloat but stops waiting once the context is done
+func (s *Sync) DecrFloatContext(ctx context.Context, key string, delta float64) (float64, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewDecrementRequest(key, FLOAT, delta))
+	if err != nil {
+		return 0, err
+	}
+	f, _ := toFloat(val)
+	return f, nil
+}
+
+// LPush will add the items to the start of the list so that the last item is first
+// and return the new length. A key that does not exist is created
+func (s *Sync) LPush(key string, items ...interface{}) (int, error) {
+	return s.LPushContext(context.Background(), key, items...)
+}
+
+// LPushContext is the same as LPush but stops waiting once the context is done
+func (s *Sync) LPushContext(ctx context.Context, key string, items ...interface{}) (int, error) {
+	items, err := normalizeItems(items)
+	if err != nil {
+		return 0, err
+	}
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewPushRequest(LPUSH, key, items...))
+	length, _ := toInt(val)
+	return length, err
+}
+
+// RPush will add the items to the end of the list and return the new length.
+// A key that does not exist is created
+func (s *Sync) RPush(key string, items ...interface{}) (int, error) {
+	return s.RPushContext(context.Background(), key, items...)
+}
+
+// RPushContext is the same as RPush but stops waiting once the context is done
+func (s *Sync) RPushContext(ctx context.Context, key string, items ...interface{}) (int, error) {
+	items, err := normalizeItems(items)
+	if err != nil {
+		return 0, err
+	}
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewPushRequest(RPUSH, key, items...))
+	length, _ := toInt(val)
+	return length, err
+}
+
+// LPop will remove and return the first item of the list
+func (s *Sync) LPop(key string) (interface{}, error) {
+	return s.LPopContext(context.Background(), key)
+}
+
+// LPopContext is the same as LPop but stops waiting once the context is done
+func (s *Sync) LPopContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewPopRequest(LPOP, key, 0))
+}
+
+// RPop will remove and return the last item of the list
+func (s *Sync) RPop(key string) (interface{}, error) {
+	return s.RPopContext(context.Background(), key)
+}
+
+// RPopContext is the same as RPop but stops waiting once the context is done
+func (s *Sync) RPopContext(ctx context.Context, key string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewPopRequest(RPOP, key, 0))
+}
+
+// BLPop will remove and return the first item of the list waiting until the
+// timeout for an item to be pushed if the list is empty
+func (s *Sync) BLPop(key string, timeout time.Duration) (interface{}, error) {
+	return s.BLPopContext(context.Background(), key, timeout)
+}
+
+// BLPopContext will remove and return the first item of the list waiting until
+// the timeout, or the deadline of the context if sooner, for an item to be pushed
+func (s *Sync) BLPopContext(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewPopRequest(LPOP, key, timeout))
+}
+
+// BRPop will remove and return the last item of the list waiting until the
+// timeout for an item to be pushed if the list is empty
+func (s *Sync) BRPop(key string, timeout time.Duration) (interface{}, error) {
+	return s.BRPopContext(context.Background(), key, timeout)
+}
+
+// BRPopContext will remove and return the last item of the list waiting until
+// the timeout, or the deadline of the context if sooner, for an item to be pushed
+func (s *Sync) BRPopContext(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewPopRequest(RPOP, key, timeout))
+}
+
+// LRange returns the items from start to stop (inclusive). Negative indexes are
+// counted from the end of the list so 0 to -1 returns every item
+func (s *Sync) LRange(key string, start, stop int) ([]interface{}, error) {
+	return s.LRangeContext(context.Background(), key, start, stop)
+}
+
+// LRangeContext is the same as LRange but stops waiting once the context is done
+func (s *Sync) LRangeContext(ctx context.Context, key string, start, stop int) ([]interface{}, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewRangeRequest(key, start, stop))
+	items, _ := val.([]interface{})
+	return items, err
+}
+
+// LTrim will remove all of the items except those from start to stop (inclusive)
+func (s *Sync) LTrim(key string, start, stop int) error {
+	return s.LTrimContext(context.Background(), key, start, stop)
+}
+
+// LTrimContext is the same as LTrim but stops waiting once the context is done
+func (s *Sync) LTrimContext(ctx context.Context, key string, start, stop int) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewTrimRequest(key, start, stop))
+}
+
+// LLen returns the length of the list
+func (s *Sync) LLen(key string) (int, error) {
+	return s.LLenContext(context.Background(), key)
+}
+
+// LLenContext is the same as LLen but stops waiting once the context is done
+func (s *Sync) LLenContext(ctx context.Context, key string) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewLengthRequest(key))
+	length, _ := toInt(val)
+	return length, err
+}
+
+// HGet returns the value of the field of the map
+func (s *Sync) HGet(key, field string) (interface{}, error) {
+	return s.HGetContext(context.Background(), key, field)
+}
+
+// HGetContext is the same as HGet but stops waiting once the context is done
+func (s *Sync) HGetContext(ctx context.Context, key, field string) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewFieldReadRequest(key, field))
+}
+
+// HSet will store the value for the field of the map. A key that does not exist is created
+func (s *Sync) HSet(key, field string, value interface{}) error {
+	return s.HSetContext(context.Background(), key, field, value)
+}
+
+// HSetContext is the same as HSet but stops waiting once the context is done
+func (s *Sync) HSetContext(ctx context.Context, key, field string, value interface{}) error {
+	value, err := normalizeItem(value)
+	if err != nil {
+		return err
+	}
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewFieldWriteRequest(key, field, value))
+}
+
+// HDel will delete the field of the map
+func (s *Sync) HDel(key, field string) error {
+	return s.HDelContext(context.Background(), key, field)
+}
+
+// HDelContext is the same as HDel but stops waiting once the context is done
+func (s *Sync) HDelContext(ctx context.Context, key, field string) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewFieldDeleteRequest(key, field))
+}
+
+// HKeys returns the fields of the map in order
+func (s *Sync) HKeys(key string) ([]string, error) {
+	return s.HKeysContext(context.Background(), key)
+}
+
+// HKeysContext is the same as HKeys but stops waiting once the context is done
+func (s *Sync) HKeysContext(ctx context.Context, key string) ([]string, error) {
+	response, err := waitForResponseContext(ctx, s.RequestChannel, NewFieldsRequest(key))
+	if response == nil {
+		return nil, err
+	}
+	return response.Keys, err
+}
+
+// HExists returns whether the field of the map exists
+func (s *Sync) HExists(key, field string) (bool, error) {
+	return s.HExistsContext(context.Background(), key, field)
+}
+
+// HExistsContext is the same as HExists but stops waiting once the context is done
+func (s *Sync) HExistsContext(ctx context.Context, key, field string) (bool, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewFieldExistsRequest(key, field))
+	exists, _ := val.(bool)
+	return exists, err
+}
+
+// HIncrInt will atomically add the delta to the INT value of the field and return
+// the new value. A field that does not exist is created with a value of zero first
+func (s *Sync) HIncrInt(key, field string, delta int) (int, error) {
+	return s.HIncrIntContext(context.Background(), key, field, delta)
+}
+
+// HIncrIntContext is the same as HIncrInt but stops waiting once the context is done
+func (s *Sync) HIncrIntContext(ctx context.Context, key, field string, delta int) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewFieldIncrementRequest(key, field, INT, delta))
+	i, _ := toInt(val)
+	return i, err
+}
+
+// HIncrFloat will atomically add the delta to the FLOAT value of the field and return
+// the new value. A field that does not exist is created with a value of zero first
+func (s *Sync) HIncrFloat(key, field string, delta float64) (float64, error) {
+	return s.HIncrFloatContext(context.Background(), key, field, delta)
+}
+
+// HIncrFloatContext is the same as HIncrFloat but stops waiting once the context is done
+func (s *Sync) HIncrFloatContext(ctx context.Context, key, field string, delta float64) (float64, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewFieldIncrementRequest(key, field, FLOAT, delta))
+	f, _ := toFloat(val)
+	return f, err
+}
+
+// PGet returns the value at the path (e.g. user.addresses[0].city) within the MAP or
+// ARRAY value of the key. The value must be of the type specified unless it is NONE
+func (s *Sync) PGet(key, path string, dType Type) (interface{}, error) {
+	return s.PGetContext(context.Background(), key, path, dType)
+}
+
+// PGetContext is the same as PGet but stops waiting once the context is done
+func (s *Sync) PGetContext(ctx context.Context, key, path string, dType Type) (interface{}, error) {
+	return waitForReadValueContext(ctx, s.RequestChannel, NewPathReadRequest(key, path, dType))
+}
+
+// PSet will store the value at the path within the MAP or ARRAY value of the key.
+// The value must be of the type specified unless it is NONE. Any maps along the
+// path that do not exist are created
+func (s *Sync) PSet(key, path string, dType Type, value interface{}) error {
+	return s.PSetContext(context.Background(), key, path, dType, value)
+}
+
+// PSetContext is the same as PSet but stops waiting once the context is done
+func (s *Sync) PSetContext(ctx context.Context, key, path string, dType Type, value interface{}) error {
+	value, err := normalizeItem(value)
+	if err != nil {
+		return err
+	}
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewPathWriteRequest(key, path, dType, value))
+}
+
+// PDelete will delete the value at the path within the MAP or ARRAY value of the key
+func (s *Sync) PDelete(key, path string) error {
+	return s.PDeleteContext(context.Background(), key, path)
+}
+
+// PDeleteContext is the same as PDelete but stops waiting once the context is done
+func (s *Sync) PDeleteContext(ctx context.Context, key, path string) error {
+	return waitForWriteValueContext(ctx, s.RequestChannel, NewPathDeleteRequest(key, path))
+}
+
+// PAppend will add the items to the end of the array at the path within the MAP or
+// ARRAY value of the key and return the new length. The array is created if it
+// does not exist
+func (s *Sync) PAppend(key, path string, items ...interface{}) (int, error) {
+	return s.PAppendContext(context.Background(), key, path, items...)
+}
+
+// PAppendContext is the same as PAppend but stops waiting once the context is done
+func (s *Sync) PAppendContext(ctx context.Context, key, path string, items ...interface{}) (int, error) {
+	items, err := normalizeItems(items)
+	if err != nil {
+		return 0, err
+	}
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewPathAppendRequest(key, path, items...))
+	length, _ := toInt(val)
+	return length, err
+}
+
+// SAdd will add the members to the set returning the number that were not already
+// members. A key that does not exist is created
+func (s *Sync) SAdd(key string, members ...string) (int, error) {
+	return s.SAddContext(context.Background(), key, members...)
+}
+
+// SAddContext is the same as SAdd but stops waiting once the context is done
+func (s *Sync) SAddContext(ctx context.Context, key string, members ...string) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewSetRequest(SADD, key, members...))
+	added, _ := toInt(val)
+	return added, err
+}
+
+// SRem will remove the members from the set returning the number that were removed
+func (s *Sync) SRem(key string, members ...string) (int, error) {
+	return s.SRemContext(context.Background(), key, members...)
+}
+
+// SRemContext is the same as SRem but stops waiting once the context is done
+func (s *Sync) SRemContext(ctx context.Context, key string, members ...string) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewSetRequest(SREM, key, members...))
+	removed, _ := toInt(val)
+	return removed, err
+}
+
+// SIsMember returns whether the member is within the set
+func (s *Sync) SIsMember(key, member string) (bool, error) {
+	return s.SIsMemberContext(context.Background(), key, member)
+}
+
+// SIsMemberContext is the same as SIsMember but stops waiting once the context is done
+func (s *Sync) SIsMemberContext(ctx context.Context, key, member string) (bool, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewIsMemberRequest(key, member))
+	isMember, _ := val.(bool)
+	return isMember, err
+}
+
+// SMembers returns the members of the set in order
+func (s *Sync) SMembers(key string) (Set, error) {
+	return s.SMembersContext(context.Background(), key)
+}
+
+// SMembersContext is the same as SMembers but stops waiting once the context is done
+func (s *Sync) SMembersContext(ctx context.Context, key string) (Set, error) {
+	return waitForSet(ctx, s.RequestChannel, NewMembersRequest(key))
+}
+
+// SUnion returns the members that are within any of the sets
+func (s *Sync) SUnion(keys ...string) (Set, error) {
+	return s.SUnionContext(context.Background(), keys...)
+}
+
+// SUnionContext is the same as SUnion but stops waiting once the context is done
+func (s *Sync) SUnionContext(ctx context.Context, keys ...string) (Set, error) {
+	return waitForSet(ctx, s.RequestChannel, NewSetCombineRequest(SUNION, keys...))
+}
+
+// SInter returns the members that are within every one of the sets
+func (s *Sync) SInter(keys ...string) (Set, error) {
+	return s.SInterContext(context.Background(), keys...)
+}
+
+// SInterContext is the same as SInter but stops waiting once the context is done
+func (s *Sync) SInterContext(ctx context.Context, keys ...string) (Set, error) {
+	return waitForSet(ctx, s.RequestChannel, NewSetCombineRequest(SINTER, keys...))
+}
+
+// SDiff returns the members of the first set that are not within any of the others
+func (s *Sync) SDiff(keys ...string) (Set, error) {
+	return s.SDiffContext(context.Background(), keys...)
+}
+
+// SDiffContext is the same as SDiff but stops waiting once the context is done
+func (s *Sync) SDiffContext(ctx context.Context, keys ...string) (Set, error) {
+	return waitForSet(ctx, s.RequestChannel, NewSetCombineRequest(SDIFF, keys...))
+}
+
+// waitForSet will block until the set has arrived or the context is done
+func waitForSet(ctx context.Context, requestChannel chan *Request, request *Request) (Set, error) {
+	val, err := waitForReadValueContext(ctx, requestChannel, request)
+	if err != nil {
+		return nil, err
+	}
+	set, _ := toSet(val)
+	return set, nil
+}
+
+// ZAdd will add the members to the sorted set (or change the score of those that are
+// already members) returning the number that were added. A key that does not exist
+// is created
+func (s *Sync) ZAdd(key string, members ...ZMember) (int, error) {
+	return s.ZAddContext(context.Background(), key, members...)
+}
+
+// ZAddContext is the same as ZAdd but stops waiting once the context is done
+func (s *Sync) ZAddContext(ctx context.Context, key string, members ...ZMember) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewZAddRequest(key, members...))
+	added, _ := toInt(val)
+	return added, err
+}
+
+// ZRem will remove the members from the sorted set returning the number that were removed
+func (s *Sync) ZRem(key string, members ...string) (int, error) {
+	return s.ZRemContext(context.Background(), key, members...)
+}
+
+// ZRemContext is the same as ZRem but stops waiting once the context is done
+func (s *Sync) ZRemContext(ctx context.Context, key string, members ...string) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewZRemRequest(key, members...))
+	removed, _ := toInt(val)
+	return removed, err
+}
+
+// ZScore returns the score of the member of the sorted set
+func (s *Sync) ZScore(key, member string) (float64, error) {
+	return s.ZScoreContext(context.Background(), key, member)
+}
+
+// ZScoreContext is the same as ZScore but stops waiting once the context is done
+func (s *Sync) ZScoreContext(ctx context.Context, key, member string) (float64, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewZMemberRequest(ZSCORE, key, member))
+	score, _ := toFloat(val)
+	return score, err
+}
+
+// ZRank returns the rank of the member of the sorted set where the member with the
+// lowest score has a rank of zero
+func (s *Sync) ZRank(key, member string) (int, error) {
+	return s.ZRankContext(context.Background(), key, member)
+}
+
+// ZRankContext is the same as ZRank but stops waiting once the context is done
+func (s *Sync) ZRankContext(ctx context.Context, key, member string) (int, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewZMemberRequest(ZRANK, key, member))
+	rank, _ := toInt(val)
+	return rank, err
+}
+
+// ZRange returns the members from the start to stop rank (inclusive). Negative
+// ranks are counted from the end of the sorted set so 0 to -1 returns every member
+func (s *Sync) ZRange(key string, start, stop int) (ZSet, error) {
+	return s.ZRangeContext(context.Background(), key, start, stop)
+}
+
+// ZRangeContext is the same as ZRange but stops waiting once the context is done
+func (s *Sync) ZRangeContext(ctx context.Context, key string, start, stop int) (ZSet, error) {
+	return waitForZSet(ctx, s.RequestChannel, NewZRangeRequest(key, start, stop))
+}
+
+// ZRangeByScore returns the members with a score from min to max (inclusive)
+func (s *Sync) ZRangeByScore(key string, min, max float64) (ZSet, error) {
+	return s.ZRangeByScoreContext(context.Background(), key, min, max)
+}
+
+// ZRangeByScoreContext is the same as ZRangeByScore but stops waiting once the context is done
+func (s *Sync) ZRangeByScoreContext(ctx context.Context, key string, min, max float64) (ZSet, error) {
+	return waitForZSet(ctx, s.RequestChannel, NewZRangeByScoreRequest(key, min, max))
+}
+
+// ZIncrBy will add the delta to the score of the member returning the new score.
+// A member that does not exist is added with a score of zero first
+func (s *Sync) ZIncrBy(key, member string, delta float64) (float64, error) {
+	return s.ZIncrByContext(context.Background(), key, member, delta)
+}
+
+// ZIncrByContext is the same as ZIncrBy but stops waiting once the context is done
+func (s *Sync) ZIncrByContext(ctx context.Context, key, member string, delta float64) (float64, error) {
+	val, err := waitForReadValueContext(ctx, s.RequestChannel, NewZIncrByRequest(key, member, delta))
+	score, _ := toFloat(val)
+	return score, err
+}
+
+// waitForZSet will block until the sorted set has arrived or the context is done
+func waitForZSet(ctx context.Context, requestChannel chan *Request, request *Request) (ZSet, error) {
+	val, err := waitForReadValueContext(ctx, requestChannel, request)
+	if err != nil {
+		return nil, err
+	}
+	zset, _ := toZSet(val)
+	return zset, nil
 }
@@ -0,0 +1,94 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContextVariantsStopWaiting(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(1)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	s := &Sync{RequestChannel: ks.RequestChannel}
+	s.SetInt("version", 1)
+	_, version, _ := s.GetWithVersion("version", INT)
+
+	// The shard is held so that none of the requests are answered in time
+	b := &barrier{resume: make(chan struct{})}
+	b.paused.Add(1)
+	ks.shards[0].pause <- b
+	b.paused.Wait()
+
+	calls := map[string]func(ctx context.Context) error{
+		"CompareAndSwap": func(ctx context.Context) error {
+			_, err := s.CompareAndSwapContext(ctx, "version", INT, 2, version)
+			return err
+		},
+		"Transaction": func(ctx context.Context) error {
+			_, err := s.TransactionContext(ctx, NewWriteStep("transaction", INT, 1))
+			return err
+		},
+		"HKeys": func(ctx context.Context) error {
+			_, err := s.HKeysContext(ctx, "map")
+			return err
+		},
+		"MSet": func(ctx context.Context) error {
+			return s.MSetContext(ctx, INT, map[string]interface{}{"batch": 1})
+		},
+		"LPush": func(ctx context.Context) error {
+			_, err := s.LPushContext(ctx, "list", 1)
+			return err
+		},
+		"SAdd": func(ctx context.Context) error {
+			_, err := s.SAddContext(ctx, "set", "a")
+			return err
+		},
+		"ZAdd": func(ctx context.Context) error {
+			_, err := s.ZAddContext(ctx, "zset", ZMember{Member: "a", Score: 1})
+			return err
+		},
+	}
+	for name, call := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if err := call(ctx); !errors.Is(err, ErrTimeout) {
+			t.Errorf("%sContext returned %v, expected a timeout", name, err)
+		}
+		cancel()
+	}
+
+	// The requests are dropped rather than applied once the shard continues
+	close(b.resume)
+	if val, _ := s.GetInt("version"); val != 1 {
+		t.Errorf("The compare and swap was applied after its deadline, version holds %v", val)
+	}
+	for _, key := range []string{"transaction", "batch", "list", "set", "zset"} {
+		if _, err := s.GetValue(key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetValue(%s) returned %v, expected the write to have been dropped", key, err)
+		}
+	}
+}
+
+func TestRequestErrAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	// The context is done because of its deadline so the request has timed out
+	request := NewReadRequest("key", NONE)
+	request.Done = ctx.Done()
+	request.Deadline, _ = ctx.Deadline()
+	if err := request.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	request.Deadline = time.Time{}
+	if err := request.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v without a deadline, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,150 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Step is a single operation within a transaction. The Op can be READ, WRITE,
+// DELETE, CAS, INCR, DECR, LPUSH, RPUSH, LPOP, RPOP, HGET, HSET, HDEL, HINCR, PGET,
+// PSET, PDELETE, PAPPEND or COMPARE. A COMPARE step is a precondition that checks that the
+// version of the key matches (zero if it must not exist or AnyVersion if it
+// must exist) and, if a value is provided, that the value is equal
+type Step struct {
+	Op      Op           // The operation required
+	Key     string       // The key for the operation
+	Field   string       // The field of the map (used for field steps only)
+	Path    string       // The path to the nested value (used for path steps only)
+	Value   *ValueHolder // The value (used for write, compare and swap and compare steps)
+	Version uint64       // The expected version (used for compare and swap and compare steps)
+}
+
+// NewTransactionRequest will generate a new Request for applying the steps atomically
+func NewTransactionRequest(steps ...*Step) *Request {
+	return &Request{Op: TRANSACTION, Value: &ValueHolder{Type: NONE}, Steps: steps, ResponseChannel: make(chan *Response)}
+}
+
+// NewReadStep will generate a new Step for reading a key
+func NewReadStep(key string, dType Type) *Step {
+	return &Step{Op: READ, Key: key, Value: &ValueHolder{Type: dType}}
+}
+
+// NewWriteStep will generate a new Step for writing a key
+func NewWriteStep(key string, dType Type, value interface{}) *Step {
+	return &Step{Op: WRITE, Key: key, Value: &ValueHolder{Type: dType, Val: value}}
+}
+
+// NewDeleteStep will generate a new Step for deleting a key
+func NewDeleteStep(key string) *Step {
+	return &Step{Op: DELETE, Key: key, Value: &ValueHolder{Type: NONE}}
+}
+
+// NewIncrementStep will generate a new Step for adding the delta to the value of a key
+func NewIncrementStep(key string, dType Type, delta interface{}) *Step {
+	return &Step{Op: INCR, Key: key, Value: &ValueHolder{Type: dType, Val: delta}}
+}
+
+// NewFieldWriteStep will generate a new Step for writing a field of a map
+func NewFieldWriteStep(key, field string, value interface{}) *Step {
+	return &Step{Op: HSET, Key: key, Field: field, Value: &ValueHolder{Type: NONE, Val: value}}
+}
+
+// NewPathWriteStep will generate a new Step for writing the value at a path
+func NewPathWriteStep(key, path string, dType Type, value interface{}) *Step {
+	return &Step{Op: PSET, Key: key, Path: path, Value: &ValueHolder{Type: dType, Val: value}}
+}
+
+// NewCompareStep will generate a new Step that checks the version of a key
+func NewCompareStep(key string, version uint64) *Step {
+	return &Step{Op: COMPARE, Key: key, Version: version}
+}
+
+// NewCompareValueStep will generate a new Step that checks the value of a key
+func NewCompareValueStep(key string, value interface{}) *Step {
+	return &Step{Op: COMPARE, Key: key, Value: &ValueHolder{Type: NONE, Val: value}, Version: AnyVersion}
+}
+
+// transaction will apply each of the steps in order. If any of the steps fail
+// every change made by the transaction is undone. As every shard is paused while
+// the transaction is applied no other request can see part of the transaction
+func (ks *Service) transaction(request *Request, response *Response) {
+	for _, sh := range ks.shards {
+		sh.pending = make(map[string]bool)
+	}
+	undo := make(map[string]*keyState)
+	defer func() {
+		for _, sh := range ks.shards {
+			sh.pending = nil
+		}
+	}()
+
+	// Apply each of the steps keeping the original state of each key
+	for i, step := range request.Steps {
+		result := &Response{}
+		response.Results = append(response.Results, result)
+		sh := ks.shardFor(step.Key)
+		switch step.Op {
+		case READ, WRITE, DELETE, CAS, COMPARE, INCR, DECR, LPUSH, RPUSH, LPOP, RPOP, HGET, HSET, HDEL, HINCR, PGET, PSET, PDELETE, PAPPEND:
+			if _, ok := undo[step.Key]; !ok && step.Op != READ && step.Op != COMPARE && step.Op != HGET && step.Op != PGET {
+				undo[step.Key] = sh.store.state(step.Key)
+
+				// The key may need to be restored so it must not be evicted
+				sh.pending[step.Key] = false
+			}
+			value := step.Value
+			if value == nil {
+				value = &ValueHolder{Type: NONE}
+			}
+			sh.apply(&Request{Op: step.Op, Key: step.Key, Field: step.Field, Path: step.Path, Value: value, Version: step.Version}, result)
+		default:
+			result.fail(generateError(Invalid, fmt.Sprintf("Operation %d is not supported within a transaction", step.Op)))
+		}
+
+		// Undo any changes if the step was not successful
+		if !result.Success {
+			for key, state := range undo {
+				ks.shardFor(key).store.restore(key, state)
+			}
+			response.fail(generateError(result.Code, fmt.Sprintf("The transaction failed at step %d: %s", i, result.Error)))
+			return
+		}
+	}
+
+	// Commit the changes of every shard to the log together
+	var keys []string
+	var entries []*logEntry
+	for _, sh := range ks.shards {
+		var changed []string
+		for key, ok := range sh.pending {
+			if ok {
+				changed = append(changed, key)
+			}
+		}
+		entries = append(entries, sh.store.logEntries(changed...)...)
+		keys = append(keys, changed...)
+	}
+	ks.store.appendEntries(entries)
+	atomic.AddInt64(&ks.writes, int64(len(keys)))
+	ks.notify(keys...)
+	response.Success = true
+}
+
+// compare will check that the version and optionally the value of the key match
+func (sh *shard) compare(request *Request, response *Response) {
+	current := sh.store.Version(request.Key)
+	response.Version = current
+	if request.Version != current && !(request.Version == AnyVersion && current > 0) {
+		response.fail(ErrVersionConflict)
+		return
+	}
+	if request.Value != nil && request.Value.Val != nil {
+		if val, _ := sh.store.GetValue(request.Key); !equalValues(val, request.Value.Val) {
+			response.fail(generateError(Conflict, fmt.Sprintf("The value for key '%s' does not match", request.Key)))
+			return
+		}
+	}
+	response.Success = true
+}
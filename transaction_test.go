@@ -0,0 +1,65 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// keysInShards returns a key held by each of the shards
+func keysInShards(shards int) []string {
+	keys := make([]string, shards)
+	for i, found := 0, 0; found < shards; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if n := shardOf(key, shards); keys[n] == "" {
+			keys[n] = key
+			found++
+		}
+	}
+	return keys
+}
+
+func TestTransactionRollbackAcrossShards(t *testing.T) {
+	ks := NewService("")
+	ks.UpdateShards(4)
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	keys := keysInShards(4)
+	for _, key := range keys {
+		if err := ks.SetValue(key, 1); err != nil {
+			t.Fatalf("SetValue(%s): %s", key, err)
+		}
+	}
+
+	// The failing compare is last so every write before it must be undone
+	steps := make([]*Step, 0, len(keys)+2)
+	for _, key := range keys {
+		steps = append(steps, NewWriteStep(key, INT, 2))
+	}
+	steps = append(steps, NewDeleteStep(keys[0]), NewCompareStep("missing", 1))
+	if _, err := ks.Transaction(steps...); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Transaction returned %v, expected a version conflict", err)
+	}
+	for _, key := range keys {
+		if val, err := ks.GetInt(key); err != nil || val != 1 {
+			t.Errorf("GetInt(%s) = %v, %v after the rollback, expected 1", key, val, err)
+		}
+	}
+
+	// Once the compare passes the writes of every shard are applied
+	steps[len(steps)-1] = NewCompareStep("missing", 0)
+	if _, err := ks.Transaction(steps...); err != nil {
+		t.Fatalf("Transaction: %s", err)
+	}
+	if _, err := ks.GetInt(keys[0]); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetInt(%s) returned %v, expected the key to be deleted", keys[0], err)
+	}
+	for _, key := range keys[1:] {
+		if val, err := ks.GetInt(key); err != nil || val != 2 {
+			t.Errorf("GetInt(%s) = %v, %v, expected 2", key, val, err)
+		}
+	}
+}
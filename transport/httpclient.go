@@ -3,27 +3,71 @@
 package transport
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/landonia/keystore"
 )
 
+// operations are the names of the operations used in the query
+var operations = map[keystore.Op]string{
+	keystore.INCR:          "incr",
+	keystore.DECR:          "decr",
+	keystore.HINCR:         "incr",
+	keystore.HKEYS:         "hkeys",
+	keystore.HEXISTS:       "hexists",
+	keystore.LPUSH:         "lpush",
+	keystore.RPUSH:         "rpush",
+	keystore.LPOP:          "lpop",
+	keystore.RPOP:          "rpop",
+	keystore.LRANGE:        "lrange",
+	keystore.LTRIM:         "ltrim",
+	keystore.LLEN:          "llen",
+	keystore.SADD:          "sadd",
+	keystore.SREM:          "srem",
+	keystore.SISMEMBER:     "sismember",
+	keystore.SMEMBERS:      "smembers",
+	keystore.SUNION:        "sunion",
+	keystore.SINTER:        "sinter",
+	keystore.SDIFF:         "sdiff",
+	keystore.ZADD:          "zadd",
+	keystore.ZREM:          "zrem",
+	keystore.ZSCORE:        "zscore",
+	keystore.ZRANK:         "zrank",
+	keystore.ZRANGE:        "zrange",
+	keystore.ZRANGEBYSCORE: "zrangebyscore",
+	keystore.ZINCRBY:       "zincrby",
+}
+
 // HTTPClient holds the HTTP client connection
 type HTTPClient struct {
-	*keystore.Sync           // Adopt the sync struct
-	hostaddr       string    // the address to bind to
-	quit           chan bool // The channel to wait on to finish the connection
-	connected      bool      // Whether the server is currently connected
+	*keystore.Sync                          // Adopt the sync struct
+	hostaddr       string                   // the address to bind to
+	quit           chan bool                // The channel to wait on to finish the connection
+	connected      bool                     // Whether the server is currently connected
+	streams        map[uint64]io.ReadCloser // The event streams of the watches and subscriptions
+	mutex          sync.Mutex               // Protects the streams
 }
 
 // NewHTTPClient will create a new HTTP connection using the host address
 func NewHTTPClient(hostaddr string) *HTTPClient {
-	return &HTTPClient{&keystore.Sync{RequestChannel: make(chan *keystore.Request)}, hostaddr, make(chan bool), false}
+	return &HTTPClient{
+		Sync:     &keystore.Sync{RequestChannel: make(chan *keystore.Request)},
+		hostaddr: hostaddr,
+		quit:     make(chan bool),
+		streams:  make(map[uint64]io.ReadCloser),
+	}
 }
 
 // Connect will start the event listener for incoming data
@@ -42,70 +86,555 @@ func (client *HTTPClient) Connect() {
 		for {
 			select {
 			case request := <-client.RequestChannel:
+				// Each request is made in its own routine so that a request that
+				// takes a long time to answer, such as a blocking pop, does not hold
+				// up the others
 				log.Println("Received a new client request")
+				go client.handle(request)
+			case <-client.quit:
+				log.Println("Client connection is shutting down")
 
-				// Create the correct URL for the key
-				var url string
-				if client.hostaddr[0:7] != "http://" {
-					url = fmt.Sprintf("http://%s/%s", client.hostaddr, request.Key)
-				} else {
-					url = fmt.Sprintf("%s/%s", client.hostaddr, request.Key)
-				}
+				// Will shutdown the routine
+				break
+			}
+		}
+	}()
+}
 
-				// The HTTP request is based on the type of keystore operation
-				var resp *http.Response
-				var err error
-				switch request.Op {
-				case keystore.READ:
-
-					// Make a GET request
-					log.Printf("Making GET request: %s", url)
-					resp, err = http.Get(url)
-				case keystore.WRITE:
-
-					// Encode the value to send in the body
-					var b []byte
-					if b, err = json.Marshal(request.Value.Val); err != nil {
-						log.Printf("An error occurred marshalling GET request [%s] content: %s", url, err)
-					} else {
-						// Make a POST request
-						log.Printf("Making POST request: %s", url)
-						resp, err = http.Post(url, "application/json", bytes.NewBuffer(b))
-					}
-				case keystore.DELETE:
-					// Make a DELETE request
-					var req *http.Request
-					if req, err = http.NewRequest("DELETE", url, nil); err != nil {
-						log.Printf("An error occurred making DELETE request: %s", err)
-					} else {
-						// Make the request
-						resp, err = http.DefaultClient.Do(req)
+// handle will make the HTTP request for the keystore request and deliver the
+// response to the caller
+func (client *HTTPClient) handle(request *keystore.Request) {
+	// A request the caller has stopped waiting for is never sent
+	if err := request.Err(); err != nil {
+		deliver(request, &keystore.Response{Error: err.Error(), Code: keystore.CodeOf(err)})
+		return
+	}
+	ctx, cancel := requestContext(request)
+
+	// Create the correct URL for the key (and the field of a map)
+	path := neturl.PathEscape(request.Key)
+	if request.Field != "" {
+		path = fmt.Sprintf("%s/%s", path, neturl.PathEscape(request.Field))
+	}
+	var url string
+	if client.hostaddr[0:7] != "http://" {
+		url = fmt.Sprintf("http://%s/%s", client.hostaddr, path)
+	} else {
+		url = fmt.Sprintf("%s/%s", client.hostaddr, path)
+	}
+
+	// The HTTP request is based on the type of keystore operation
+	var resp *http.Response
+	var err error
+	switch request.Op {
+	case keystore.PGET, keystore.PSET, keystore.PDELETE, keystore.PAPPEND:
+		// Make a request with the path in the query and any value in the body
+		query := neturl.Values{}
+		query.Set("path", request.Path)
+		method := "POST"
+		var body io.Reader
+		switch request.Op {
+		case keystore.PGET:
+			method = "GET"
+		case keystore.PDELETE:
+			method = "DELETE"
+		case keystore.PAPPEND:
+			query.Set("op", "append")
+		}
+		if method == "POST" {
+			var b []byte
+			if b, err = keystore.MarshalValue(request.Value.Val); err != nil {
+				break
+			}
+			body = bytes.NewBuffer(b)
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making %s request: %s", method, url)
+		resp, err = client.send(ctx, method, url, body)
+	case keystore.READ, keystore.HGET:
+
+		// Make a GET request (with any type expected in the query)
+		if name, ok := typeName(request.Value.Type); ok && request.Op == keystore.READ {
+			url = fmt.Sprintf("%s?type=%s", url, name)
+		}
+		log.Printf("Making GET request: %s", url)
+		resp, err = client.send(ctx, "GET", url, nil)
+	case keystore.WRITE, keystore.CAS, keystore.HSET:
+
+		// Encode the value to send in the body
+		var b []byte
+		if b, err = keystore.MarshalValue(request.Value.Val); err != nil {
+			log.Printf("An error occurred marshalling GET request [%s] content: %s", url, err)
+		} else {
+			// Any expiry is sent as a duration in the query along with the
+			// type so that the value is held as that type
+			query := neturl.Values{}
+			if request.Value.TTL > 0 {
+				query.Set("ttl", request.Value.TTL.String())
+			}
+			dType := request.Value.Type
+			if dType == keystore.NONE {
+				dType = keystore.TypeOf(request.Value.Val)
+			}
+			if name, ok := typeName(dType); ok && request.Op != keystore.HSET {
+				query.Set("type", name)
+			}
+			if len(query) > 0 {
+				url = fmt.Sprintf("%s?%s", url, query.Encode())
+			}
+
+			// Make a POST request (using the version as the ETag for a compare and swap)
+			log.Printf("Making POST request: %s", url)
+			var req *http.Request
+			if req, err = newHTTPRequest(ctx, "POST", url, bytes.NewBuffer(b)); err == nil {
+				if request.Op == keystore.CAS {
+					switch request.Version {
+					case 0:
+						req.Header.Set("If-None-Match", "*")
+					case keystore.AnyVersion:
+						req.Header.Set("If-Match", "*")
+					default:
+						req.Header.Set("If-Match", fmt.Sprintf("\"%d\"", request.Version))
 					}
 				}
+				resp, err = http.DefaultClient.Do(req)
+			}
+		}
+	case keystore.DELETE, keystore.HDEL:
+		// Make a DELETE request
+		log.Printf("Making DELETE request: %s", url)
+		resp, err = client.send(ctx, "DELETE", url, nil)
+	case keystore.FLUSH:
+		// Make a POST request to the flush path rather than a key
+		url = fmt.Sprintf("%s%s", url, FlushPath)
+		log.Printf("Making POST request: %s", url)
+		resp, err = client.send(ctx, "POST", url, nil)
+	case keystore.WATCH, keystore.UNWATCH, keystore.SUBSCRIBE, keystore.UNSUBSCRIBE:
+		// The events and messages are streamed in the background until
+		// the watch or subscription is ended
+		var response *keystore.Response
+		switch request.Op {
+		case keystore.WATCH:
+			response = client.watch(url, request)
+		case keystore.SUBSCRIBE:
+			response = client.subscribe(url, request)
+		default:
+			response = client.unwatch(request.Subscription)
+		}
+		cancel()
+		deliver(request, response)
+		return
+	case keystore.PUBLISH:
+		// Make a POST request to the publish path with the topic following it
+		var b []byte
+		if b, err = keystore.MarshalValue(request.Value.Val); err == nil {
+			url = fmt.Sprintf("%s%s/%s", url, PublishPath, neturl.PathEscape(request.Topic))
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.STATS:
+		// Make a GET request to the stats path rather than a key
+		url = fmt.Sprintf("%s%s", url, StatsPath)
+		log.Printf("Making GET request: %s", url)
+		resp, err = client.send(ctx, "GET", url, nil)
+	case keystore.TRANSACTION:
+		// Make a POST request to the transaction path with the steps in the body
+		var b []byte
+		if b, err = json.Marshal(request.Steps); err == nil {
+			url = fmt.Sprintf("%s%s", url, TransactionPath)
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.MREAD, keystore.MDELETE:
+		// Make a POST request to the batch path with the keys in the body
+		var b []byte
+		if b, err = json.Marshal(request.Keys); err == nil {
+			if request.Op == keystore.MREAD {
+				url = fmt.Sprintf("%s%s", url, BatchReadPath)
+			} else {
+				url = fmt.Sprintf("%s%s", url, BatchDeletePath)
+			}
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.MWRITE:
+		// Make a POST request to the batch path with the keys and values in the body
+		values := make(map[string]interface{}, len(request.Keys))
+		var ttl time.Duration
+		for i, key := range request.Keys {
+			if i < len(request.Values) && request.Values[i] != nil {
+				values[key] = request.Values[i].Val
+				ttl = request.Values[i].TTL
+			}
+		}
+		var b []byte
+		if b, err = keystore.MarshalValue(values); err == nil {
+			url = fmt.Sprintf("%s%s", url, BatchWritePath)
+			if ttl > 0 {
+				url = fmt.Sprintf("%s?ttl=%s", url, ttl)
+			}
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.INCR, keystore.DECR, keystore.HINCR:
+		// Make a POST request with the operation and delta in the query
+		query := neturl.Values{}
+		query.Set("op", operations[request.Op])
+		query.Set("delta", fmt.Sprint(request.Value.Val))
+		switch request.Value.Type {
+		case keystore.INT:
+			query.Set("type", "int")
+		case keystore.FLOAT:
+			query.Set("type", "float")
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making POST request: %s", url)
+		resp, err = client.send(ctx, "POST", url, nil)
+	case keystore.LPUSH, keystore.RPUSH:
+		// Make a POST request with the operation in the query and the items in the body
+		var b []byte
+		if b, err = keystore.MarshalValue(request.Value.Val); err == nil {
+			url = fmt.Sprintf("%s?op=%s", url, operations[request.Op])
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.LPOP, keystore.RPOP, keystore.LTRIM:
+		// Make a POST request with the operation in the query
+		query := neturl.Values{}
+		query.Set("op", operations[request.Op])
+		if request.Op == keystore.LTRIM {
+			query.Set("start", strconv.Itoa(request.Start))
+			query.Set("stop", strconv.Itoa(request.Stop))
+		} else if request.Timeout > 0 {
+			query.Set("timeout", request.Timeout.String())
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making POST request: %s", url)
+		resp, err = client.send(ctx, "POST", url, nil)
+	case keystore.LRANGE, keystore.LLEN, keystore.HKEYS, keystore.HEXISTS:
+		// Make a GET request with the operation in the query
+		query := neturl.Values{}
+		query.Set("op", operations[request.Op])
+		if request.Op == keystore.LRANGE {
+			query.Set("start", strconv.Itoa(request.Start))
+			query.Set("stop", strconv.Itoa(request.Stop))
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making GET request: %s", url)
+		resp, err = client.send(ctx, "GET", url, nil)
+	case keystore.SADD, keystore.SREM, keystore.ZREM, keystore.ZADD:
+		// Make a POST request with the operation in the query and the members in the body
+		var content interface{} = request.Value.Val
+		if request.Op == keystore.ZADD {
+			scores := make(map[string]float64)
+			if members, ok := request.Value.Val.(keystore.ZSet); ok {
+				for _, m := range members {
+					scores[m.Member] = m.Score
+				}
+			}
+			content = scores
+		}
+		var b []byte
+		if b, err = json.Marshal(content); err == nil {
+			url = fmt.Sprintf("%s?op=%s", url, operations[request.Op])
+			log.Printf("Making POST request: %s", url)
+			resp, err = client.send(ctx, "POST", url, bytes.NewBuffer(b))
+		}
+	case keystore.ZINCRBY:
+		// Make a POST request with the member and delta in the query
+		query := neturl.Values{}
+		query.Set("op", operations[request.Op])
+		if increment, ok := request.Value.Val.(keystore.ZMember); ok {
+			query.Set("member", increment.Member)
+			query.Set("delta", strconv.FormatFloat(increment.Score, 'g', -1, 64))
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making POST request: %s", url)
+		resp, err = client.send(ctx, "POST", url, nil)
+	case keystore.SISMEMBER, keystore.SMEMBERS, keystore.SUNION, keystore.SINTER, keystore.SDIFF,
+		keystore.ZSCORE, keystore.ZRANK, keystore.ZRANGE, keystore.ZRANGEBYSCORE:
+		// Make a GET request with the operation in the query
+		query := neturl.Values{}
+		query.Set("op", operations[request.Op])
+		switch request.Op {
+		case keystore.SISMEMBER, keystore.ZSCORE, keystore.ZRANK:
+			member, _ := request.Value.Val.(string)
+			query.Set("member", member)
+		case keystore.SUNION, keystore.SINTER, keystore.SDIFF:
+			// The first set is the key in the path
+			if len(request.Keys) > 0 {
+				url = strings.TrimSuffix(url, "/") + "/" + neturl.PathEscape(request.Keys[0])
+				query["key"] = request.Keys[1:]
+			}
+		case keystore.ZRANGE:
+			query.Set("start", strconv.Itoa(request.Start))
+			query.Set("stop", strconv.Itoa(request.Stop))
+		case keystore.ZRANGEBYSCORE:
+			query.Set("min", strconv.FormatFloat(request.Min, 'g', -1, 64))
+			query.Set("max", strconv.FormatFloat(request.Max, 'g', -1, 64))
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making GET request: %s", url)
+		resp, err = client.send(ctx, "GET", url, nil)
+	case keystore.SCAN:
+		// Make a GET request to the root with the options in the query
+		query := neturl.Values{}
+		if options := request.Scan; options != nil {
+			query.Set("prefix", options.Prefix)
+			query.Set("start", options.Start)
+			query.Set("end", options.End)
+			query.Set("cursor", options.Cursor)
+			query.Set("limit", strconv.Itoa(options.Limit))
+		}
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+		log.Printf("Making GET request: %s", url)
+		resp, err = client.send(ctx, "GET", url, nil)
+	default:
+		// There is no HTTP request for the operation
+		cancel()
+		deliver(request, &keystore.Response{Error: fmt.Sprintf("The operation %d is not supported by the HTTP client", request.Op), Code: keystore.Invalid})
+		return
+	}
 
-				// Check if there was an error
-				if err != nil {
-					log.Printf("An error occurred making the HTTP request [%s]: %s", url, err)
-				} else {
+	// Check if there was an error. The caller is always sent a response
+	// so that it never waits for a request that failed
+	response := &keystore.Response{}
+	if err != nil {
+		log.Printf("An error occurred making the HTTP request [%s]: %s", url, err)
+		response.Error, response.Code = err.Error(), keystore.Unavailable
+		if expired := request.Err(); expired != nil {
+			response.Error, response.Code = expired.Error(), keystore.CodeOf(expired)
+		}
+	} else {
 
-					// Now just handle the response by gob'ling it up
-					response := &keystore.Response{}
-					if err = json.NewDecoder(io.LimitReader(resp.Body, MaxRequestLength)).Decode(response); err != nil {
-						log.Printf("An error occurred decoding HTTP response: %s", err)
-					}
-					log.Println("Received response from HTTP request")
+		// Now just handle the response by gob'ling it up. Any response
+		// that is not JSON is given the code for its status
+		if err = json.NewDecoder(io.LimitReader(resp.Body, MaxResponseLength)).Decode(response); err != nil {
+			log.Printf("An error occurred decoding HTTP response: %s", err)
+			response.Error, response.Code = resp.Status, statusCode(resp.StatusCode)
+		}
+		resp.Body.Close()
+		log.Println("Received response from HTTP request")
+	}
+	cancel()
 
-					// Send the response
-					go func() { request.ResponseChannel <- response }()
-				}
-			case <-client.quit:
-				log.Println("Client connection is shutting down")
+	// Send the response
+	deliver(request, response)
+}
 
-				// Will shutdown the routine
+// requestContext returns the context for the HTTP request that is done once
+// the deadline of the request has passed or the caller stops waiting
+func requestContext(request *keystore.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !request.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(context.Background(), request.Deadline)
+	}
+	if request.Done != nil {
+		go func() {
+			select {
+			case <-request.Done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// newHTTPRequest will create the HTTP request using the context. The time left
+// before the deadline of the context is sent so that the server can drop the
+// request if it is still waiting to be applied once the client has given up
+func newHTTPRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(TimeoutHeader, time.Until(deadline).String())
+	}
+	return req, nil
+}
+
+// send will make the HTTP request using the context
+func (client *HTTPClient) send(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	req, err := newHTTPRequest(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// watch will make a request to the watch path and send each of the events
+// streamed in the response to the channel of the request
+func (client *HTTPClient) watch(url string, request *keystore.Request) *keystore.Response {
+	query := neturl.Values{}
+	if request.Watch != nil {
+		query["key"] = request.Watch.Keys
+		query["prefix"] = request.Watch.Prefixes
+	}
+	url = fmt.Sprintf("%s%s?%s", url, WatchPath, query.Encode())
+	if request.Events == nil {
+		return &keystore.Response{Error: "A channel is required to receive the events of a watch", Code: keystore.Invalid}
+	}
+	return client.stream(url, func(id uint64, data []byte) bool {
+		event := &keystore.Event{}
+		if err := json.Unmarshal(data, event); err != nil {
+			log.Printf("An error occurred decoding the event: %s", err)
+			return true
+		}
+		select {
+		case request.Events <- event:
+			return true
+		default:
+			log.Printf("Closing watch %d as it is not reading the events", id)
+			return false
+		}
+	}, func() { close(request.Events) })
+}
+
+// subscribe will make a request to the subscribe path and send each of the
+// messages streamed in the response to the channel of the request
+func (client *HTTPClient) subscribe(url string, request *keystore.Request) *keystore.Response {
+	query := neturl.Values{"topic": request.Patterns}
+	url = fmt.Sprintf("%s%s?%s", url, SubscribePath, query.Encode())
+	if request.Messages == nil {
+		return &keystore.Response{Error: "A channel is required to receive the messages of a subscription", Code: keystore.Invalid}
+	}
+	return client.stream(url, func(id uint64, data []byte) bool {
+		message := &keystore.Message{}
+		if err := json.Unmarshal(data, message); err != nil {
+			log.Printf("An error occurred decoding the message: %s", err)
+			return true
+		}
+		select {
+		case request.Messages <- message:
+			return true
+		default:
+			log.Printf("Closing subscription %d as it is not reading the messages", id)
+			return false
+		}
+	}, func() { close(request.Messages) })
+}
+
+// stream will make a request for the server sent events at the url. The first
+// event is the response holding the id of the watch or subscription and each
+// of the events that follow is passed to send until it returns false or the
+// stream is closed, at which point done is called
+func (client *HTTPClient) stream(url string, send func(uint64, []byte) bool, done func()) *keystore.Response {
+	log.Printf("Making GET request: %s", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return &keystore.Response{Error: err.Error(), Code: keystore.Unavailable}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return &keystore.Response{Error: fmt.Sprintf("Unable to start the stream: %s", resp.Status), Code: statusCode(resp.StatusCode)}
+	}
+
+	// The first event holds the id
+	reader := bufio.NewReader(resp.Body)
+	response := &keystore.Response{}
+	if _, data, err := readEvent(reader); err != nil {
+		response.Error, response.Code = err.Error(), keystore.Unavailable
+	} else if err = json.Unmarshal(data, response); err != nil {
+		response.Error, response.Code = err.Error(), keystore.Unavailable
+	}
+	if !response.Success {
+		resp.Body.Close()
+		return response
+	}
+	id := response.Subscription
+	client.mutex.Lock()
+	client.streams[id] = resp.Body
+	client.mutex.Unlock()
+
+	// The stream ends once it is closed or the events are not being read
+	go func() {
+		defer done()
+		defer resp.Body.Close()
+		for {
+			_, data, err := readEvent(reader)
+			if err != nil || !send(id, data) {
 				break
 			}
 		}
+		client.mutex.Lock()
+		delete(client.streams, id)
+		client.mutex.Unlock()
 	}()
+	return response
+}
+
+// unwatch will end the watch or subscription by closing its stream
+func (client *HTTPClient) unwatch(id uint64) *keystore.Response {
+	client.mutex.Lock()
+	stream, ok := client.streams[id]
+	delete(client.streams, id)
+	client.mutex.Unlock()
+	if !ok {
+		return &keystore.Response{Error: fmt.Sprintf("The watch or subscription %d does not exist", id), Code: keystore.NotFound}
+	}
+	stream.Close()
+	return &keystore.Response{Success: true}
+}
+
+// typeName returns the name of the type used in the query or false if the
+// type does not have a name
+func typeName(dType keystore.Type) (string, bool) {
+	for name, t := range typeNames {
+		if t == dType {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// statusCode returns the error code for the status of a response that did not
+// hold the code itself
+func statusCode(status int) keystore.ErrorCode {
+	switch status {
+	case http.StatusNotFound:
+		return keystore.NotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return keystore.Conflict
+	case http.StatusInsufficientStorage:
+		return keystore.StoreFull
+	case http.StatusRequestTimeout:
+		return keystore.Timeout
+	case http.StatusServiceUnavailable:
+		return keystore.Unavailable
+	case http.StatusUnprocessableEntity:
+		return keystore.WrongType
+	case http.StatusBadRequest, http.StatusMethodNotAllowed:
+		return keystore.Invalid
+	}
+	return keystore.UnknownError
+}
+
+// readEvent will read the next server sent event returning its name and data
+func readEvent(reader *bufio.Reader) (string, []byte, error) {
+	var name string
+	var data []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if name != "" || data != nil {
+				return name, data, nil
+			}
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(line[len("data:"):])...)
+		}
+	}
 }
 
 // Close will stop this client connection
@@ -117,7 +646,8 @@ func (client *HTTPClient) Close() {
 	}()
 }
 
-// SendRequest will push the request onto the channel
+// SendRequest will push the request onto the channel without waiting for the
+// response. SendAsync will also return a future for the response
 func (client *HTTPClient) SendRequest(request *keystore.Request) {
 	// Spawn off the request to the channel
 	go func() {
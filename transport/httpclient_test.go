@@ -0,0 +1,152 @@
+// Landon Wainwright.
+
+package transport
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/landonia/keystore"
+)
+
+// newHTTPTestClient will serve the service over HTTP on a loopback address and
+// return a client connected to it
+func newHTTPTestClient(t *testing.T, ks *keystore.Service) *HTTPClient {
+	t.Helper()
+	server := httptest.NewServer(generateHandler(ks.RequestChannel, operationHandler))
+	client := NewHTTPClient(server.URL)
+	client.Connect()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestHTTPClientUnknownOperation(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	client := newHTTPTestClient(t, ks)
+
+	// A transaction step can not be sent on its own
+	request := keystore.NewReadRequest("key", keystore.INT)
+	request.Op = keystore.COMPARE
+	if _, err := client.SendAsync(request).Wait(); !errors.Is(err, keystore.ErrInvalid) {
+		t.Errorf("The unknown operation returned %v, expected it to be invalid", err)
+	}
+	if err := client.SetValue("key", 1); err != nil {
+		t.Errorf("The client failed after the unknown operation: %s", err)
+	}
+}
+
+func TestHTTPClientBlockingPopDoesNotHoldUpOthers(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	client := newHTTPTestClient(t, ks)
+	pop := client.SendAsync(keystore.NewPopRequest(keystore.LPOP, "queue", 5*time.Second))
+
+	// The other requests are answered while the pop is waiting for an item
+	start := time.Now()
+	if err := client.SetString("key", "value"); err != nil {
+		t.Fatalf("SetString: %s", err)
+	}
+	if _, err := client.RPush("queue", "item"); err != nil {
+		t.Fatalf("RPush: %s", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("The requests waited %s for the blocking pop", time.Since(start))
+	}
+	response, err := pop.Wait()
+	if err != nil || response.Value == nil || response.Value.Val != "item" {
+		t.Errorf("The blocking pop returned %+v, %v, expected item", response, err)
+	}
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	handler := generateHandler(ks.RequestChannel, operationHandler)
+	client := newHTTPTestClient(t, ks)
+	client.SetInt("max", math.MaxInt)
+	client.SetString("string", "value")
+
+	tests := map[string]struct {
+		request  *http.Request
+		status   int
+		expected error
+	}{
+		"invalid":    {httptest.NewRequest("POST", "/max?op=incr&type=int", nil), http.StatusBadRequest, keystore.ErrInvalid},
+		"wrong type": {httptest.NewRequest("POST", "/string?op=incr&type=int", nil), http.StatusUnprocessableEntity, keystore.ErrWrongType},
+		"not found":  {httptest.NewRequest("GET", "/missing", nil), http.StatusNotFound, keystore.ErrNotFound},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, test.request)
+			if w.Code != test.status {
+				t.Errorf("The status was %d, expected %d", w.Code, test.status)
+			}
+			if code := statusCode(w.Code); code != keystore.CodeOf(test.expected) {
+				t.Errorf("The status %d is read back as %s, expected %s", w.Code, code, keystore.CodeOf(test.expected))
+			}
+		})
+	}
+	if _, err := client.IncrInt("max", 1); !errors.Is(err, keystore.ErrInvalid) {
+		t.Errorf("IncrInt returned %v, expected it to be invalid", err)
+	}
+}
+
+func TestHTTPClientKeepsNestedNumberTypes(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	client := newHTTPTestClient(t, ks)
+
+	value := map[string]interface{}{"int": 3, "float": 3.0, "items": []interface{}{1, 2.5, 4.0}}
+	if err := client.SetMap("key", value); err != nil {
+		t.Fatalf("SetMap: %s", err)
+	}
+	for name, get := range map[string]func(string) (interface{}, error){"service": ks.GetValue, "client": client.GetValue} {
+		if val, err := get("key"); err != nil || !reflect.DeepEqual(val, value) {
+			t.Errorf("GetValue through the %s = %#v, %v, expected %#v", name, val, err, value)
+		}
+	}
+}
+
+func TestHTTPClientAsyncMethods(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	client := newHTTPTestClient(t, ks)
+
+	// The HTTP requests may be applied in any order so each write is waited for
+	if _, err := client.SAddAsync("set", "b", "a").Wait(); err != nil {
+		t.Fatalf("SAddAsync: %s", err)
+	}
+	if _, err := client.ZAddAsync("zset", keystore.ZMember{Member: "a", Score: 1.5}).Wait(); err != nil {
+		t.Fatalf("ZAddAsync: %s", err)
+	}
+	if _, err := client.SetAsync("float", keystore.FLOAT, float32(2)).Wait(); err != nil {
+		t.Fatalf("SetAsync: %s", err)
+	}
+	members := client.SMembersAsync("set")
+	zset := client.GetZSetAsync("zset")
+	float := client.GetFloatAsync("float")
+	if val, err := members.Wait(); err != nil || !reflect.DeepEqual(val, keystore.Set{"a", "b"}) {
+		t.Errorf("SMembersAsync = %v, %v, expected [a b]", val, err)
+	}
+	if val, err := zset.Wait(); err != nil || !reflect.DeepEqual(val, keystore.ZSet{{Member: "a", Score: 1.5}}) {
+		t.Errorf("GetZSetAsync = %v, %v, expected a with 1.5", val, err)
+	}
+	if val, err := float.Wait(); err != nil || val != 2 {
+		t.Errorf("GetFloatAsync = %g, %v, expected 2", val, err)
+	}
+}
@@ -4,9 +4,14 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/landonia/keystore"
@@ -17,6 +22,48 @@ import (
 // on the request. 10Kb will be big enough for this example.
 const MaxRequestLength int64 = 1024
 
+// MaxBatchRequestLength specifies the amount of bytes accepted on a request
+// that contains many operations such as a transaction
+const MaxBatchRequestLength int64 = 1024 * 1024
+
+// MaxResponseLength specifies the amount of bytes accepted by the client on a response
+const MaxResponseLength int64 = 16 * 1024 * 1024
+
+// FlushPath is the path used to request that the store is flushed to disk
+const FlushPath = "_flush"
+
+// TransactionPath is the path used to apply a list of steps atomically
+const TransactionPath = "_txn"
+
+// BatchReadPath is the path used to read many keys listed in the body
+const BatchReadPath = "_mget"
+
+// BatchWritePath is the path used to write many keys and values held in the body
+const BatchWritePath = "_mset"
+
+// BatchDeletePath is the path used to delete many keys listed in the body
+const BatchDeletePath = "_mdel"
+
+// StatsPath is the path used to read the size, limits and evictions of the store
+const StatsPath = "_stats"
+
+// WatchPath is the path used to stream the events for changes to the keys
+// using server sent events
+const WatchPath = "_watch"
+
+// PublishPath is the path used to publish the value in the body to the topic
+// that follows it e.g. /_publish/cache.users
+const PublishPath = "_publish"
+
+// SubscribePath is the path used to stream the messages published to the topics
+// using server sent events
+const SubscribePath = "_subscribe"
+
+// TimeoutHeader is the header holding the time the client will wait for the
+// response as a duration e.g. 1.5s. The request is dropped rather than applied
+// if it is still waiting to be applied once the time has passed
+const TimeoutHeader = "X-Keystore-Timeout"
+
 // StartHTTPServer will start a new HTTP server allowing requests
 // to be made to the key store service over a REST interface
 func StartHTTPServer(addr string, requestChannel chan<- *keystore.Request) {
@@ -42,33 +89,236 @@ func generateHandler(requestChannel chan<- *keystore.Request, handler func(http.
 // using incorrect paths
 func operationHandler(w http.ResponseWriter, r *http.Request, requestChannel chan<- *keystore.Request) {
 	var request *keystore.Request
-	// Extract the key name from the URL
-	if key := r.URL.Path[1:]; key == "" {
-		w.WriteHeader(http.StatusNotFound)
+	// Extract the key name (and the field of a map) from the URL
+	key, field := splitPath(r.URL)
+	if key == "" {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Listing the keys e.g. /?prefix=user.&limit=10&cursor=user.10
+		query := r.URL.Query()
+		options := keystore.ScanOptions{
+			Prefix: query.Get("prefix"),
+			Start:  query.Get("start"),
+			End:    query.Get("end"),
+			Cursor: query.Get("cursor"),
+		}
+		if limit := query.Get("limit"); limit != "" {
+			var err error
+			if options.Limit, err = strconv.Atoi(limit); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		request = keystore.NewScanRequest(options)
+	} else if key == FlushPath {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// A request to flush the store to disk
+		request = keystore.NewFlushRequest()
+	} else if key == StatsPath {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// A request for the stats of the store
+		request = keystore.NewStatsRequest()
+	} else if key == WatchPath {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The events are streamed until the client closes the request
+		watchHandler(w, r, requestChannel)
+		return
+	} else if key == SubscribePath {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The messages are streamed until the client closes the request
+		subscribeHandler(w, r, requestChannel)
 		return
+	} else if key == PublishPath {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The topic follows the path and the body holds the value
+		content, err := decodeBody(r, MaxRequestLength)
+		if err != nil || field == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request = keystore.NewPublishRequest(field, keystore.NONE, content)
+	} else if key == TransactionPath {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The body holds the list of steps
+		var steps []*keystore.Step
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxBatchRequestLength)).Decode(&steps); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request = keystore.NewTransactionRequest(steps...)
+	} else if key == BatchReadPath || key == BatchDeletePath {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The body holds the list of keys
+		var keys []string
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxBatchRequestLength)).Decode(&keys); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if key == BatchReadPath {
+			request = keystore.NewBatchReadRequest(keystore.NONE, keys...)
+		} else {
+			request = keystore.NewBatchDeleteRequest(keys...)
+		}
+	} else if key == BatchWritePath {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The body holds an object of the keys and values
+		content, err := decodeBody(r, MaxBatchRequestLength)
+		values, ok := content.(map[string]interface{})
+		if err != nil || !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ttl, err := parseTTL(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request = keystore.NewBatchWriteRequest(keystore.NONE, values)
+		for _, value := range request.Values {
+			value.TTL = ttl
+		}
+	} else if path := r.URL.Query().Get("path"); path != "" && field == "" {
+		// An operation on the value at a path e.g. /key?path=user.addresses[0].city
+		var err error
+		if request, err = pathRequest(key, path, r); err == errMethodNotAllowed {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else if field != "" {
+		// An operation on the field of a map e.g. /key/field
+		var err error
+		if request, err = fieldRequest(key, field, r); err == errMethodNotAllowed {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else if op := r.URL.Query().Get("op"); op != "" && (r.Method == "POST" || r.Method == "GET") {
+		// An operation on the value of the key e.g. /key?op=incr&delta=5
+		var err error
+		if request, err = operationRequest(key, op, r); err == errMethodNotAllowed {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
 	} else if r.Method == "POST" {
 		// Read the body into a string for json decoding
-		var content interface{}
-		err := json.NewDecoder(io.LimitReader(r.Body, MaxRequestLength)).Decode(&content)
+		content, err := decodeBody(r, MaxRequestLength)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
+		// An optional time to live can be provided as a duration e.g. ?ttl=30s
+		ttl, err := parseTTL(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// An optional type can be provided so the value is held as that type
+		// e.g. ?type=float, as a json number that is whole is otherwise an int
+		dType, err := parseType(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if content, err = keystore.ConvertValue(dType, content); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
 		// The key and value are now retrieved
-		request = keystore.NewWriteRequest(key, keystore.NONE, content)
+		request = keystore.NewWriteRequestWithTTL(key, dType, content, ttl)
+
+		// The write is only made if the If-Match or If-None-Match headers match
+		var conditional bool
+		if request.Version, conditional, err = parsePrecondition(r); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		} else if conditional {
+			request.Op = keystore.CAS
+		}
 	} else if r.Method == "GET" {
 
-		// A read request
-		request = keystore.NewReadRequest(key, keystore.NONE)
+		// A read request, which fails if the value is not of any type given
+		dType, err := parseType(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		request = keystore.NewReadRequest(key, dType)
 	} else if r.Method == "DELETE" {
 		// A delete request
 		request = keystore.NewDeleteRequest(key)
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The request is only applied whilst the client is still waiting for it
+	timeout, err := parseTimeout(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
+	if timeout > 0 {
+		request.Deadline = time.Now().Add(timeout)
+	}
+	request.Done = r.Context().Done()
 
 	// Send the request to the keystore
-	requestChannel <- request
+	select {
+	case requestChannel <- request:
+	case <-r.Context().Done():
+		return
+	}
+
+	// Wait for a minute (after any time the request may wait) and then send a timeout
+	timer := time.NewTimer(time.Minute*1 + request.Timeout)
+	defer timer.Stop()
 
 	// Get the response channel from the request
 	select {
@@ -80,15 +330,472 @@ func operationHandler(w http.ResponseWriter, r *http.Request, requestChannel cha
 			return
 		}
 
+		// The version of the key is used as the ETag
+		if response.Version > 0 {
+			w.Header().Set("ETag", etag(response.Version))
+		}
+		if r.Method == "GET" && response.Success && response.Version > 0 {
+			if match := r.Header.Get("If-None-Match"); match == "*" || match == etag(response.Version) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
 		// Set the correct content type
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(responseStatus(request, response))
 
 		// Write the content back
 		w.Write(content)
-	case <-time.NewTimer(time.Minute * 1).C:
-
-		// Wait for a minute and then send a timeout
+	case <-timer.C:
 		w.WriteHeader(http.StatusRequestTimeout)
+	case <-r.Context().Done():
+
+		// The client has stopped waiting for the response
+		log.Printf("The client stopped waiting for the response to %s", r.URL)
+	}
+}
+
+// watchHandler will stream the events for the keys as server sent events
+// e.g. /_watch?key=config&prefix=user. The first event holds the id of the watch.
+// The stream ends if the events are not read quickly enough
+func watchHandler(w http.ResponseWriter, r *http.Request, requestChannel chan<- *keystore.Request) {
+	query := r.URL.Query()
+	options := keystore.WatchOptions{Keys: query["key"], Prefixes: query["prefix"]}
+	events := make(chan *keystore.Event, keystore.DefaultWatchBuffer)
+	request := keystore.NewWatchRequest(options, events)
+	flusher, response := startStream(w, "watch", request, requestChannel)
+	if flusher == nil {
+		return
+	}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			name := "write"
+			if event.Op == keystore.DELETE {
+				name = "delete"
+			}
+			writeEvent(w, name, event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			// The client has gone so the watch is ended
+			request := keystore.NewUnwatchRequest(response.Subscription)
+			go func() {
+				requestChannel <- request
+				<-request.ResponseChannel
+			}()
+			return
+		}
+	}
+}
+
+// subscribeHandler will stream the messages published to the topics as server
+// sent events e.g. /_subscribe?topic=cache.*. The first event holds the id of the
+// subscription. The stream ends if the messages are not read quickly enough
+func subscribeHandler(w http.ResponseWriter, r *http.Request, requestChannel chan<- *keystore.Request) {
+	messages := make(chan *keystore.Message, keystore.DefaultSubscribeBuffer)
+	request := keystore.NewSubscribeRequest(messages, r.URL.Query()["topic"]...)
+	flusher, response := startStream(w, "subscribe", request, requestChannel)
+	if flusher == nil {
+		return
+	}
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			writeEvent(w, "message", message)
+			flusher.Flush()
+		case <-r.Context().Done():
+			// The client has gone so the subscription is ended
+			request := keystore.NewUnsubscribeRequest(response.Subscription)
+			go func() {
+				requestChannel <- request
+				<-request.ResponseChannel
+			}()
+			return
+		}
+	}
+}
+
+// startStream will send the watch or subscribe request and begin the server sent
+// events with the response, so that the client has the id. Nil is returned if
+// the request failed
+func startStream(w http.ResponseWriter, name string, request *keystore.Request, requestChannel chan<- *keystore.Request) (http.Flusher, *keystore.Response) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil
+	}
+	requestChannel <- request
+	response := <-request.ResponseChannel
+	if !response.Success {
+		http.Error(w, response.Error, responseStatus(request, response))
+		return nil, nil
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	writeEvent(w, name, response)
+	flusher.Flush()
+	return flusher, response
+}
+
+// writeEvent will write the server sent event with the data encoded as json
+func writeEvent(w io.Writer, name string, data interface{}) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding the %s event: %s", name, err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, content)
+}
+
+// errorStatus is the HTTP status for each of the error codes
+var errorStatus = map[keystore.ErrorCode]int{
+	keystore.UnknownError: http.StatusInternalServerError,
+	keystore.NotFound:     http.StatusNotFound,
+	keystore.WrongType:    http.StatusUnprocessableEntity,
+	keystore.Conflict:     http.StatusConflict,
+	keystore.StoreFull:    http.StatusInsufficientStorage,
+	keystore.Timeout:      http.StatusRequestTimeout,
+	keystore.Unavailable:  http.StatusServiceUnavailable,
+	keystore.Invalid:      http.StatusBadRequest,
+}
+
+// responseStatus returns the HTTP status for the code of the response. A
+// conflict for a write made conditional by the If-Match or If-None-Match
+// headers is a failed precondition
+func responseStatus(request *keystore.Request, response *keystore.Response) int {
+	code := response.Code
+	if code == keystore.NoError && response.Error != "" {
+		code = keystore.UnknownError
+	}
+	if code == keystore.Conflict && request.Op == keystore.CAS {
+		return http.StatusPreconditionFailed
+	}
+	if status, ok := errorStatus[code]; ok {
+		return status
+	}
+	return http.StatusOK
+}
+
+// etag returns the ETag header value for the version of a key
+func etag(version uint64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// errMethodNotAllowed is returned when the operation is not available for the method
+var errMethodNotAllowed = errors.New("The operation is not available for the method")
+
+// readOperations are the operations made using a GET request rather than a POST
+var readOperations = map[string]bool{
+	"lrange":        true,
+	"llen":          true,
+	"hkeys":         true,
+	"hexists":       true,
+	"sismember":     true,
+	"smembers":      true,
+	"sunion":        true,
+	"sinter":        true,
+	"sdiff":         true,
+	"zscore":        true,
+	"zrank":         true,
+	"zrange":        true,
+	"zrangebyscore": true,
+}
+
+// setOperations are the operations that combine many sets
+var setOperations = map[string]keystore.Op{"sunion": keystore.SUNION, "sinter": keystore.SINTER, "sdiff": keystore.SDIFF}
+
+// operationRequest will generate the request for an operation on the value of
+// the key using the parameters in the query
+func operationRequest(key, op string, r *http.Request) (*keystore.Request, error) {
+	if (r.Method == "GET") != readOperations[op] {
+		return nil, errMethodNotAllowed
+	}
+	query := r.URL.Query()
+	switch op {
+	case "incr", "decr":
+		dType, delta, err := parseDelta(query)
+		if err != nil {
+			return nil, err
+		}
+		if op == "incr" {
+			return keystore.NewIncrementRequest(key, dType, delta), nil
+		}
+		return keystore.NewDecrementRequest(key, dType, delta), nil
+	case "lpush", "rpush":
+		// The body holds the list of items to push
+		var items []interface{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxBatchRequestLength)).Decode(&items); err != nil {
+			return nil, err
+		}
+		if op == "lpush" {
+			return keystore.NewPushRequest(keystore.LPUSH, key, items...), nil
+		}
+		return keystore.NewPushRequest(keystore.RPUSH, key, items...), nil
+	case "lpop", "rpop":
+		// An optional timeout to wait for an item e.g. ?op=lpop&timeout=30s
+		var timeout time.Duration
+		if raw := query.Get("timeout"); raw != "" {
+			var err error
+			if timeout, err = time.ParseDuration(raw); err != nil {
+				return nil, err
+			}
+		}
+		if op == "lpop" {
+			return keystore.NewPopRequest(keystore.LPOP, key, timeout), nil
+		}
+		return keystore.NewPopRequest(keystore.RPOP, key, timeout), nil
+	case "lrange", "ltrim":
+		// The range of items e.g. ?op=lrange&start=0&stop=-1
+		start, err := strconv.Atoi(query.Get("start"))
+		if err != nil {
+			return nil, err
+		}
+		stop, err := strconv.Atoi(query.Get("stop"))
+		if err != nil {
+			return nil, err
+		}
+		if op == "lrange" {
+			return keystore.NewRangeRequest(key, start, stop), nil
+		}
+		return keystore.NewTrimRequest(key, start, stop), nil
+	case "llen":
+		return keystore.NewLengthRequest(key), nil
+	case "hkeys":
+		return keystore.NewFieldsRequest(key), nil
+	case "sadd", "srem", "zrem":
+		// The body holds the list of members
+		var members []string
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxBatchRequestLength)).Decode(&members); err != nil {
+			return nil, err
+		}
+		switch op {
+		case "sadd":
+			return keystore.NewSetRequest(keystore.SADD, key, members...), nil
+		case "srem":
+			return keystore.NewSetRequest(keystore.SREM, key, members...), nil
+		}
+		return keystore.NewZRemRequest(key, members...), nil
+	case "sismember":
+		return keystore.NewIsMemberRequest(key, query.Get("member")), nil
+	case "smembers":
+		return keystore.NewMembersRequest(key), nil
+	case "sunion", "sinter", "sdiff":
+		// The other sets are given as keys e.g. /a?op=sunion&key=b&key=c
+		keys := append([]string{key}, query["key"]...)
+		return keystore.NewSetCombineRequest(setOperations[op], keys...), nil
+	case "zadd":
+		// The body holds an object of the members and their scores
+		var scores map[string]float64
+		if err := json.NewDecoder(io.LimitReader(r.Body, MaxBatchRequestLength)).Decode(&scores); err != nil {
+			return nil, err
+		}
+		members := make([]keystore.ZMember, 0, len(scores))
+		for member, score := range scores {
+			members = append(members, keystore.ZMember{Member: member, Score: score})
+		}
+		return keystore.NewZAddRequest(key, members...), nil
+	case "zscore":
+		return keystore.NewZMemberRequest(keystore.ZSCORE, key, query.Get("member")), nil
+	case "zrank":
+		return keystore.NewZMemberRequest(keystore.ZRANK, key, query.Get("member")), nil
+	case "zrange":
+		start, err := strconv.Atoi(query.Get("start"))
+		if err != nil {
+			return nil, err
+		}
+		stop, err := strconv.Atoi(query.Get("stop"))
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewZRangeRequest(key, start, stop), nil
+	case "zrangebyscore":
+		// The scores can be unbounded e.g. ?op=zrangebyscore&min=-inf&max=100
+		min, err := strconv.ParseFloat(query.Get("min"), 64)
+		if err != nil {
+			return nil, err
+		}
+		max, err := strconv.ParseFloat(query.Get("max"), 64)
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewZRangeByScoreRequest(key, min, max), nil
+	case "zincrby":
+		delta, err := strconv.ParseFloat(query.Get("delta"), 64)
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewZIncrByRequest(key, query.Get("member"), delta), nil
+	}
+	return nil, fmt.Errorf("Unknown operation %s", op)
+}
+
+// fieldRequest will generate the request for an operation on the field of a map
+func fieldRequest(key, field string, r *http.Request) (*keystore.Request, error) {
+	op := r.URL.Query().Get("op")
+	if op != "" && (r.Method == "GET") != readOperations[op] {
+		return nil, errMethodNotAllowed
+	}
+	switch {
+	case op == "incr":
+		dType, delta, err := parseDelta(r.URL.Query())
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewFieldIncrementRequest(key, field, dType, delta), nil
+	case op == "hexists":
+		return keystore.NewFieldExistsRequest(key, field), nil
+	case op != "":
+		return nil, fmt.Errorf("Unknown operation %s", op)
+	case r.Method == "GET":
+		return keystore.NewFieldReadRequest(key, field), nil
+	case r.Method == "POST":
+		content, err := decodeBody(r, MaxRequestLength)
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewFieldWriteRequest(key, field, content), nil
+	case r.Method == "DELETE":
+		return keystore.NewFieldDeleteRequest(key, field), nil
+	}
+	return nil, errMethodNotAllowed
+}
+
+// pathRequest will generate the request for an operation on the value at a path
+func pathRequest(key, path string, r *http.Request) (*keystore.Request, error) {
+	op := r.URL.Query().Get("op")
+	switch {
+	case op == "append" && r.Method == "POST":
+		content, err := decodeBody(r, MaxBatchRequestLength)
+		items, ok := content.([]interface{})
+		if err != nil || !ok {
+			return nil, errors.New("The items to append must be an array")
+		}
+		return keystore.NewPathAppendRequest(key, path, items...), nil
+	case op != "":
+		return nil, fmt.Errorf("Unknown operation %s", op)
+	case r.Method == "GET":
+		return keystore.NewPathReadRequest(key, path, keystore.NONE), nil
+	case r.Method == "POST":
+		content, err := decodeBody(r, MaxBatchRequestLength)
+		if err != nil {
+			return nil, err
+		}
+		return keystore.NewPathWriteRequest(key, path, keystore.NONE, content), nil
+	case r.Method == "DELETE":
+		return keystore.NewPathDeleteRequest(key, path), nil
+	}
+	return nil, errMethodNotAllowed
+}
+
+// splitPath returns the key and the optional field of a map from the path of
+// the URL e.g. /key/field. A slash within a key or field must be escaped as %2F
+func splitPath(u *neturl.URL) (key, field string) {
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		field, _ = neturl.PathUnescape(path[i+1:])
+		path = path[:i]
+	}
+	key, _ = neturl.PathUnescape(path)
+	return
+}
+
+// parseDelta returns the type and delta of an increment. The delta defaults to one
+// and the type is determined by the current value unless it is given
+// e.g. ?op=incr&delta=2.5&type=float
+func parseDelta(query neturl.Values) (keystore.Type, interface{}, error) {
+	var delta interface{} = 1
+	if raw := query.Get("delta"); raw != "" {
+		if i, err := strconv.Atoi(raw); err == nil {
+			delta = i
+		} else if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			delta = f
+		} else {
+			return keystore.NONE, nil, err
+		}
+	}
+	dType := keystore.NONE
+	switch query.Get("type") {
+	case "int":
+		dType = keystore.INT
+	case "float":
+		dType = keystore.FLOAT
+	}
+	return dType, delta, nil
+}
+
+// parseTTL returns the optional time to live provided as a duration e.g. ?ttl=30s
+func parseTTL(r *http.Request) (time.Duration, error) {
+	if rawTTL := r.URL.Query().Get("ttl"); rawTTL != "" {
+		return time.ParseDuration(rawTTL)
+	}
+	return 0, nil
+}
+
+// typeNames are the names of the types used in the query
+var typeNames = map[string]keystore.Type{
+	"bool":   keystore.BOOL,
+	"int":    keystore.INT,
+	"float":  keystore.FLOAT,
+	"string": keystore.STRING,
+	"array":  keystore.ARRAY,
+	"map":    keystore.MAP,
+	"set":    keystore.SET,
+	"zset":   keystore.ZSET,
+}
+
+// parseType returns the optional type of the value e.g. ?type=int or NONE if
+// no type is given
+func parseType(r *http.Request) (keystore.Type, error) {
+	name := r.URL.Query().Get("type")
+	if name == "" {
+		return keystore.NONE, nil
+	}
+	if dType, ok := typeNames[name]; ok {
+		return dType, nil
+	}
+	return keystore.NONE, fmt.Errorf("Unknown type %s", name)
+}
+
+// decodeBody will decode the json held in the body of the request in to the
+// canonical form of the value, reading no more than the limit
+func decodeBody(r *http.Request, limit int64) (interface{}, error) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, limit))
+	if err != nil {
+		return nil, err
+	}
+	return keystore.UnmarshalValue(b)
+}
+
+// parseTimeout returns the optional time the client will wait for the response
+// provided as a duration in the timeout header
+func parseTimeout(r *http.Request) (time.Duration, error) {
+	if rawTimeout := r.Header.Get(TimeoutHeader); rawTimeout != "" {
+		return time.ParseDuration(rawTimeout)
+	}
+	return 0, nil
+}
+
+// parsePrecondition returns the version expected by the If-Match or If-None-Match
+// headers of a write request and whether the write is conditional
+func parsePrecondition(r *http.Request) (uint64, bool, error) {
+	if match := r.Header.Get("If-Match"); match == "*" {
+		return keystore.AnyVersion, true, nil
+	} else if match != "" {
+		version, err := strconv.ParseUint(strings.Trim(match, "\""), 10, 64)
+		return version, true, err
+	}
+	if match := r.Header.Get("If-None-Match"); match == "*" {
+		return 0, true, nil
+	} else if match != "" {
+		return 0, false, errors.New("Only * is supported by If-None-Match for a write")
 	}
+	return 0, false, nil
 }
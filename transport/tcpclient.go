@@ -4,26 +4,61 @@ package transport
 
 import (
 	"encoding/gob"
+	"errors"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/landonia/keystore"
 )
 
-// TCPClient holds the TCP client connection
+// DefaultMaxInFlight is the number of requests that can be sent on a TCP
+// connection before the response to any of them has been received
+const DefaultMaxInFlight = 64
+
+// TCPClient holds the TCP client connection. Many requests are sent on the
+// connection without waiting for the responses, which are matched to the
+// requests by their id as the server may answer them in any order
 type TCPClient struct {
-	*keystore.Sync              // Adopt the sync struct
-	hostaddr       string       // the address to bind to
-	conn           net.Conn     // The tcp connection
-	quit           chan bool    // The channel to wait on to finish the connection
-	encoder        *gob.Encoder // The encoder for this connection
-	decoder        *gob.Decoder // The decoder for this connection
-	connected      bool         // Whether the server is currently connected
+	*keystore.Sync                                   // Adopt the sync struct
+	hostaddr       string                            // the address to bind to
+	conn           net.Conn                          // The tcp connection
+	quit           chan bool                         // The channel to wait on to finish the connection
+	encoder        *gob.Encoder                      // The encoder for this connection
+	decoder        *gob.Decoder                      // The decoder for this connection
+	connected      bool                              // Whether the server is currently connected
+	closed         chan struct{}                     // Closed once the connection has closed
+	maxInFlight    int                               // The number of requests that can be waiting for a response
+	window         chan struct{}                     // Holds a value for each request waiting for a response
+	lastID         uint64                            // The id of the last request sent
+	pending        map[uint64]*keystore.Request      // The requests waiting for a response by id
+	watches        map[uint64]chan *keystore.Event   // The channels of the watches made using the connection
+	subscriptions  map[uint64]chan *keystore.Message // The channels of the subscriptions made using the connection
+	mutex          sync.Mutex                        // Protects the pending requests, watches and subscriptions
 }
 
 // NewTCPClient will create a new TCP connection using the host address
 func NewTCPClient(hostaddr string) *TCPClient {
-	return &TCPClient{&keystore.Sync{RequestChannel: make(chan *keystore.Request)}, hostaddr, nil, make(chan bool), nil, nil, false}
+	return &TCPClient{
+		Sync:          &keystore.Sync{RequestChannel: make(chan *keystore.Request)},
+		hostaddr:      hostaddr,
+		quit:          make(chan bool),
+		closed:        make(chan struct{}),
+		maxInFlight:   DefaultMaxInFlight,
+		pending:       make(map[uint64]*keystore.Request),
+		watches:       make(map[uint64]chan *keystore.Event),
+		subscriptions: make(map[uint64]chan *keystore.Message),
+	}
+}
+
+// UpdateMaxInFlight will change the number of requests that can be sent on the
+// connection before the response to any of them has been received. Once the
+// limit has been reached any other requests wait to be sent. It must be called
+// before the client is connected
+func (client *TCPClient) UpdateMaxInFlight(max int) {
+	if max > 0 {
+		client.maxInFlight = max
+	}
 }
 
 // Connect will start the event listener for incoming data
@@ -43,46 +78,213 @@ func (client *TCPClient) Connect() {
 	log.Printf("TCP client now connected to address: %s", client.hostaddr)
 	client.encoder = gob.NewEncoder(client.conn)
 	client.decoder = gob.NewDecoder(client.conn)
+	client.window = make(chan struct{}, client.maxInFlight)
 	client.connected = true
 
+	// The responses are read separately as the events of any watches and the
+	// messages of any subscriptions are sent on the connection at any time
+	go client.read()
+
 	// Listen for requests to send on the channel
 	go func() {
 		for {
 			select {
 			case request := <-client.RequestChannel:
 				log.Println("Received a new client request")
-
-				// Use the encoder to send the request directly
-				// NOTE - It would make sense in reality to use protocol buffers here to allow
-				// other systems to easily encode/decode the required payload
-				if err := client.encoder.Encode(request); err != nil {
-					log.Printf("An error occurred encoding TCP request: %s", err)
-				}
-				log.Println("Waiting for client response")
-
-				// Wait for the response
-				response := &keystore.Response{}
-				if err := client.decoder.Decode(response); err != nil {
-					log.Printf("An error occurred decoding TCP request: %s", err)
-					if response.Error == "" {
-						response.Error = err.Error()
-					}
-				}
-				log.Println("Received response from server")
-
-				// Send the response
-				go func() { request.ResponseChannel <- response }()
+				client.send(request)
 			case <-client.quit:
 				log.Println("Client connection is shutting down")
 
-				// Close the connection
+				// Close the connection, after which any requests are answered
+				// with an error once the responses have stopped being read
 				client.conn.Close()
-				break
 			}
 		}
 	}()
 }
 
+// send will write the request on the connection once there is room in the
+// window for it. The response is delivered by the reader once it has arrived
+func (client *TCPClient) send(request *keystore.Request) {
+
+	// A request the caller has stopped waiting for is never sent
+	if err := request.Err(); err != nil {
+		deliver(request, &keystore.Response{Error: err.Error(), Code: keystore.CodeOf(err)})
+		return
+	}
+	select {
+	case client.window <- struct{}{}:
+	case <-request.Done:
+		err := request.Err()
+		deliver(request, &keystore.Response{Error: err.Error(), Code: keystore.CodeOf(err)})
+		return
+	case <-client.closed:
+		deliver(request, &keystore.Response{Error: errConnectionClosed.Error(), Code: keystore.Unavailable})
+		return
+	}
+
+	// The request is waiting for its response before it has been written as the
+	// response may arrive before the encoder has returned
+	client.mutex.Lock()
+	select {
+	case <-client.closed:
+		client.mutex.Unlock()
+		<-client.window
+		deliver(request, &keystore.Response{Error: errConnectionClosed.Error(), Code: keystore.Unavailable})
+		return
+	default:
+	}
+	client.lastID++
+	request.ID = client.lastID
+	client.pending[request.ID] = request
+	client.mutex.Unlock()
+
+	// Use the encoder to send the request directly
+	// NOTE - It would make sense in reality to use protocol buffers here to allow
+	// other systems to easily encode/decode the required payload
+	if err := client.encoder.Encode(request); err != nil {
+		log.Printf("An error occurred encoding TCP request: %s", err)
+		if client.complete(request.ID) != nil {
+			deliver(request, &keystore.Response{Error: errConnectionClosed.Error(), Code: keystore.Unavailable})
+		}
+	}
+}
+
+// complete will remove the request waiting for the response with the id and
+// free its place in the window. The request is returned or nil if it is not
+// waiting
+func (client *TCPClient) complete(id uint64) *keystore.Request {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	request, ok := client.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(client.pending, id)
+	<-client.window
+	return request
+}
+
+// errConnectionClosed is returned for any requests once the connection has closed
+var errConnectionClosed = errors.New("The connection has closed")
+
+// deliver will send the response to the caller of the request unless the
+// caller has stopped waiting for it
+func deliver(request *keystore.Request, response *keystore.Response) {
+	go func() {
+		select {
+		case request.ResponseChannel <- response:
+		case <-request.Done:
+		}
+	}()
+}
+
+// read will decode each of the responses from the connection. The events and
+// messages are sent to the channels of their watches and subscriptions and any
+// other response is delivered to the request with the same id
+func (client *TCPClient) read() {
+	for {
+		response := &keystore.Response{}
+		if err := client.decoder.Decode(response); err != nil {
+			log.Printf("An error occurred decoding TCP response: %s", err)
+			client.closeRequests()
+			client.closeSubscriptions()
+			return
+		}
+		if response.ID == 0 && (response.Event != nil || response.Message != nil || (response.Subscription != 0 && response.Code == keystore.Unavailable)) {
+			client.receive(response)
+			continue
+		}
+		log.Println("Received response from server")
+		request := client.complete(response.ID)
+		if request == nil {
+			log.Printf("Received a TCP response for an unknown request %d", response.ID)
+			continue
+		}
+
+		// The watch or subscription is ready to receive once the id is known.
+		// This happens before any other responses are read so no events are missed
+		if response.Success && request.Op == keystore.WATCH {
+			client.mutex.Lock()
+			client.watches[response.Subscription] = request.Events
+			client.mutex.Unlock()
+		} else if response.Success && request.Op == keystore.SUBSCRIBE {
+			client.mutex.Lock()
+			client.subscriptions[response.Subscription] = request.Messages
+			client.mutex.Unlock()
+		}
+		deliver(request, response)
+	}
+}
+
+// closeRequests will answer every request waiting for a response with an error
+// once the connection has closed. Any requests sent afterwards are answered
+// with the same error
+func (client *TCPClient) closeRequests() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	close(client.closed)
+	for id, request := range client.pending {
+		delete(client.pending, id)
+		<-client.window
+		deliver(request, &keystore.Response{Error: errConnectionClosed.Error(), Code: keystore.Unavailable})
+	}
+}
+
+// receive will send the event or message to the channel of its watch or
+// subscription. If they are not being read then the watch or subscription is ended
+func (client *TCPClient) receive(response *keystore.Response) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	id := response.Subscription
+	if events, ok := client.watches[id]; ok {
+		if response.Event != nil {
+			select {
+			case events <- response.Event:
+				return
+			default:
+				log.Printf("Closing watch %d as it is not reading the events", id)
+				client.end(keystore.NewUnwatchRequest(id))
+			}
+		}
+		delete(client.watches, id)
+		close(events)
+	} else if messages, ok := client.subscriptions[id]; ok {
+		if response.Message != nil {
+			select {
+			case messages <- response.Message:
+				return
+			default:
+				log.Printf("Closing subscription %d as it is not reading the messages", id)
+				client.end(keystore.NewUnsubscribeRequest(id))
+			}
+		}
+		delete(client.subscriptions, id)
+		close(messages)
+	}
+}
+
+// end will send the request to end a watch or subscription without waiting for the response
+func (client *TCPClient) end(request *keystore.Request) {
+	request.ResponseChannel = make(chan *keystore.Response, 1)
+	client.SendRequest(request)
+}
+
+// closeSubscriptions will close the channel of every watch and subscription
+// once the connection has closed
+func (client *TCPClient) closeSubscriptions() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	for id, events := range client.watches {
+		delete(client.watches, id)
+		close(events)
+	}
+	for id, messages := range client.subscriptions {
+		delete(client.subscriptions, id)
+		close(messages)
+	}
+}
+
 // Close will stop this client connection
 func (client *TCPClient) Close() {
 
@@ -92,7 +294,8 @@ func (client *TCPClient) Close() {
 	}()
 }
 
-// SendRequest will push the request onto the channel
+// SendRequest will push the request onto the channel without waiting for the
+// response. SendAsync will also return a future for the response
 func (client *TCPClient) SendRequest(request *keystore.Request) {
 	// Spawn off the request to the channel
 	go func() {
@@ -0,0 +1,166 @@
+// Landon Wainwright.
+
+package transport
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/landonia/keystore"
+)
+
+// dialTCP will serve the service over TCP on a loopback address and return a
+// client connected to it that sends at most maxInFlight requests at a time
+func dialTCP(t *testing.T, ks *keystore.Service, maxInFlight int) *TCPClient {
+	t.Helper()
+	server := newTCPServer("127.0.0.1:0", ks.RequestChannel)
+	server.connect()
+	client := NewTCPClient(server.listener.Addr().String())
+	client.UpdateMaxInFlight(maxInFlight)
+	client.Connect()
+	return client
+}
+
+func TestTCPClientPipelinesRequests(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	client := dialTCP(t, ks, DefaultMaxInFlight)
+	defer client.Close()
+
+	// A blocking pop is answered after the requests sent behind it
+	pop := client.BLPopAsync("queue", 200*time.Millisecond)
+
+	requests := 3 * DefaultMaxInFlight
+	writes := make([]keystore.Awaitable, requests)
+	reads := make([]*keystore.Future[int], requests)
+	for i := 0; i < requests; i++ {
+		key := fmt.Sprintf("key%d", i)
+		writes[i] = client.SetAsync(key, keystore.INT, i)
+		reads[i] = client.GetIntAsync(key)
+	}
+	if err := keystore.WaitAll(writes...); err != nil {
+		t.Fatalf("A write failed: %s", err)
+	}
+	for i, read := range reads {
+		if val, err := read.Wait(); err != nil || val != i {
+			t.Errorf("The read of key%d returned %d, %v, expected %d", i, val, err, i)
+		}
+	}
+	select {
+	case <-pop.Done():
+		t.Error("The blocking pop was answered before its timeout")
+	default:
+	}
+	if _, err := pop.Wait(); !errors.Is(err, keystore.ErrTimeout) {
+		t.Errorf("The blocking pop returned %v, expected a timeout", err)
+	}
+}
+
+func TestTCPClientCloseAnswersPendingRequests(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	const inFlight = 4
+	client := dialTCP(t, ks, inFlight)
+	pops := make([]*keystore.Future[interface{}], inFlight)
+	for i := range pops {
+		pops[i] = client.BLPopAsync(fmt.Sprintf("queue%d", i), time.Minute)
+	}
+
+	// Every pop must have been written before the connection is closed
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		client.mutex.Lock()
+		pending := len(client.pending)
+		client.mutex.Unlock()
+		if pending == inFlight {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d of the %d pops were sent", pending, inFlight)
+		}
+	}
+	client.Close()
+
+	for i, pop := range pops {
+		select {
+		case <-pop.Done():
+			if err := pop.Err(); !errors.Is(err, keystore.ErrUnavailable) {
+				t.Errorf("The pop of queue%d returned %v, expected unavailable", i, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The pop of queue%d was not answered once the connection closed", i)
+		}
+	}
+	if err := client.GetIntAsync("key").Err(); !errors.Is(err, keystore.ErrUnavailable) {
+		t.Errorf("A request sent after the close returned %v, expected unavailable", err)
+	}
+}
+
+func TestTCPServerEndsWatchAnsweredAfterDisconnect(t *testing.T) {
+	requests := make(chan *keystore.Request)
+	conn, serverConn := net.Pipe()
+	handler := newTCPClientHandler(serverConn, requests)
+	handler.start()
+	if err := gob.NewEncoder(conn).Encode(keystore.NewWatchRequest(keystore.WatchOptions{Keys: []string{"key"}}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	watch := <-requests
+
+	// The connection closes before the watch has been answered
+	conn.Close()
+	select {
+	case <-handler.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The handler did not see the connection close")
+	}
+	watch.ResponseChannel <- &keystore.Response{Success: true, Subscription: 7}
+
+	select {
+	case unwatch := <-requests:
+		if unwatch.Op != keystore.UNWATCH || unwatch.Subscription != 7 {
+			t.Errorf("Received %+v, expected the watch to be ended", unwatch)
+		}
+		unwatch.ResponseChannel <- &keystore.Response{Success: true}
+	case <-time.After(5 * time.Second):
+		t.Error("The watch was not ended once the connection had closed")
+	}
+}
+
+func TestTCPServerEndsPopsOnDisconnect(t *testing.T) {
+	ks := keystore.NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	conn, serverConn := net.Pipe()
+	handler := newTCPClientHandler(serverConn, ks.RequestChannel)
+	handler.start()
+
+	// The length is answered once the pop sent before it is waiting for an item
+	encoder, decoder := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	pop := keystore.NewPopRequest(keystore.LPOP, "queue", time.Minute)
+	pop.ID = 1
+	length := keystore.NewLengthRequest("queue")
+	length.ID = 2
+	for _, request := range []*keystore.Request{pop, length} {
+		if err := encoder.Encode(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if response := (&keystore.Response{}); decoder.Decode(response) != nil || response.ID != 2 {
+		t.Fatalf("Received %+v, expected the length of the list", response)
+	}
+	conn.Close()
+	<-handler.closed
+
+	// The pop has ended along with the connection so it does not take the item
+	if _, err := ks.RPush("queue", "item"); err != nil {
+		t.Fatalf("RPush: %s", err)
+	}
+	if length, err := ks.LLen("queue"); err != nil || length != 1 {
+		t.Errorf("LLen(queue) = %d, %v, expected the item to remain", length, err)
+	}
+}
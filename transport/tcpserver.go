@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/landonia/keystore"
 )
@@ -23,9 +24,12 @@ type TCPServer struct {
 type TCPClientHandler struct {
 	requestChannel chan<- *keystore.Request // The request channel
 	conn           net.Conn                 // The tcp connection
-	quit           chan bool                // The channel to wait on to finish the connection
-	encoder        *gob.Encoder             // The encoder for this connection
+	closed         chan struct{}            // Closed once the connection has closed
+	encoder        *gob.Encoder             // The encoder for this connection (only used by the writer)
+	responses      chan *keystore.Response  // The responses, events and messages waiting to be written
 	decoder        *gob.Decoder             // The decoder for this connection
+	subscriptions  map[uint64]keystore.Op   // The watches and subscriptions made using this connection
+	mutex          sync.Mutex               // Protects the subscriptions
 }
 
 // StartTCPServer will start a new TCP server allowing requests
@@ -111,7 +115,13 @@ func (server *TCPServer) handleClient(conn net.Conn) {
 // newTCPClientHandler will wrap the client connection
 // and listen for new requests
 func newTCPClientHandler(conn net.Conn, requests chan<- *keystore.Request) *TCPClientHandler {
-	client := &TCPClientHandler{requestChannel: requests, conn: conn, quit: make(chan bool)}
+	client := &TCPClientHandler{
+		requestChannel: requests,
+		conn:           conn,
+		closed:         make(chan struct{}),
+		responses:      make(chan *keystore.Response, DefaultMaxInFlight),
+		subscriptions:  make(map[uint64]keystore.Op),
+	}
 	client.encoder = gob.NewEncoder(conn)
 	client.decoder = gob.NewDecoder(conn)
 	return client
@@ -122,6 +132,9 @@ func (tcp *TCPClientHandler) start() {
 	clientaddr := tcp.conn.RemoteAddr().String()
 	log.Printf("Received new client TCP connection: %s", clientaddr)
 
+	// Only the writer uses the encoder so the responses are never interleaved
+	go tcp.write()
+
 	// Listen for requests to send on the channel
 	go func() {
 		for {
@@ -133,26 +146,155 @@ func (tcp *TCPClientHandler) start() {
 				// In both cases we shall also close the connection
 				log.Printf("Client [%s] has closed the TCP connection", clientaddr)
 				tcp.conn.Close()
+				close(tcp.closed)
+				tcp.closeSubscriptions()
 
 				// Exit out of the routine
 				return
 			}
 			log.Printf("Received TCP request from client: [%s]", clientaddr)
 
-			// Wait for the response and send it back to the client
-			go func() {
+			// The channel can not be sent so will be created. The other requests
+			// are dropped, and any that are waiting such as a blocking pop end, once
+			// the connection has closed. A watch or subscription is always answered
+			// so that it can be ended along with the connection
+			request.ResponseChannel = make(chan *keystore.Response)
+			switch request.Op {
+			case keystore.WATCH:
+				request.Events = make(chan *keystore.Event, keystore.DefaultWatchBuffer)
+			case keystore.SUBSCRIBE:
+				request.Messages = make(chan *keystore.Message, keystore.DefaultSubscribeBuffer)
+			default:
+				request.Done = tcp.closed
+			}
 
-				// The channel can not be sent so will be created
-				request.ResponseChannel = make(chan *keystore.Response)
+			// Now send the request on the request channel. The requests are sent
+			// in the order they were received so they are applied in that order
+			tcp.requestChannel <- request
 
-				// Now send the request on the request channel
-				tcp.requestChannel <- request
-				response := <-request.ResponseChannel
+			// Wait for the response and send it back to the client. The requests
+			// may be answered in any order and the client matches the responses
+			// to the requests using the id
+			go func() {
+				var response *keystore.Response
+				select {
+				case response = <-request.ResponseChannel:
+				case <-request.Done:
+					return
+				}
+
+				// The watch or subscription is recorded before the client is told of it
+				// so that it is always ended along with the connection
+				var end keystore.Op
+				if response.Success && request.Op == keystore.WATCH {
+					end = keystore.UNWATCH
+				} else if response.Success && request.Op == keystore.SUBSCRIBE {
+					end = keystore.UNSUBSCRIBE
+				}
+				if end != 0 && !tcp.addSubscription(response.Subscription, end) {
+					return
+				}
 
 				// We want to send the response back to the client
 				log.Printf("Received response... Sending TCP response to client [%s]", clientaddr)
-				err = tcp.encoder.Encode(response)
+				response.ID = request.ID
+				tcp.send(response)
+
+				// The events and messages are streamed to the client once it has the id
+				if end == keystore.UNWATCH {
+					tcp.streamEvents(response.Subscription, request.Events)
+				} else if end == keystore.UNSUBSCRIBE {
+					tcp.streamMessages(response.Subscription, request.Messages)
+				}
 			}()
 		}
 	}()
 }
+
+// write will encode each of the responses, events and messages on the
+// connection until it has closed
+func (tcp *TCPClientHandler) write() {
+	for {
+		select {
+		case response := <-tcp.responses:
+			if err := tcp.encoder.Encode(response); err != nil {
+				log.Printf("An error occurred encoding TCP response: %s", err)
+				tcp.conn.Close()
+			}
+		case <-tcp.closed:
+			return
+		}
+	}
+}
+
+// send will queue the response to be written on the connection. It is dropped
+// if the connection has closed
+func (tcp *TCPClientHandler) send(response *keystore.Response) {
+	select {
+	case tcp.responses <- response:
+	case <-tcp.closed:
+	}
+}
+
+// streamEvents will send each of the events of the watch to the client on the
+// connection. Once the watch has ended ErrSubscriptionClosed is sent
+func (tcp *TCPClientHandler) streamEvents(id uint64, events <-chan *keystore.Event) {
+	for event := range events {
+		tcp.send(&keystore.Response{Success: true, Subscription: id, Event: event})
+	}
+	tcp.removeSubscription(id)
+}
+
+// streamMessages will send each of the messages of the subscription to the client
+// on the connection. Once the subscription has ended ErrSubscriptionClosed is sent
+func (tcp *TCPClientHandler) streamMessages(id uint64, messages <-chan *keystore.Message) {
+	for message := range messages {
+		tcp.send(&keystore.Response{Success: true, Subscription: id, Message: message})
+	}
+	tcp.removeSubscription(id)
+}
+
+// addSubscription will record the watch or subscription made using the connection
+// along with the operation used to end it. If the connection has already closed it
+// is ended instead and false is returned
+func (tcp *TCPClientHandler) addSubscription(id uint64, end keystore.Op) bool {
+	tcp.mutex.Lock()
+	defer tcp.mutex.Unlock()
+	select {
+	case <-tcp.closed:
+		tcp.endSubscription(id, end)
+		return false
+	default:
+	}
+	tcp.subscriptions[id] = end
+	return true
+}
+
+// removeSubscription will tell the client that the watch or subscription has ended
+func (tcp *TCPClientHandler) removeSubscription(id uint64) {
+	tcp.send(&keystore.Response{Subscription: id, Error: keystore.ErrSubscriptionClosed.Error(), Code: keystore.Unavailable})
+	tcp.mutex.Lock()
+	delete(tcp.subscriptions, id)
+	tcp.mutex.Unlock()
+}
+
+// closeSubscriptions will end every watch and subscription made using the connection
+func (tcp *TCPClientHandler) closeSubscriptions() {
+	tcp.mutex.Lock()
+	defer tcp.mutex.Unlock()
+	for id, end := range tcp.subscriptions {
+		tcp.endSubscription(id, end)
+	}
+}
+
+// endSubscription will end the watch or subscription using the operation
+func (tcp *TCPClientHandler) endSubscription(id uint64, end keystore.Op) {
+	request := keystore.NewUnwatchRequest(id)
+	if end == keystore.UNSUBSCRIBE {
+		request = keystore.NewUnsubscribeRequest(id)
+	}
+	go func() {
+		tcp.requestChannel <- request
+		<-request.ResponseChannel
+	}()
+}
@@ -7,10 +7,15 @@ import (
 	"encoding/gob"
 	"log"
 	"net"
+	"time"
 
 	"github.com/landonia/keystore"
 )
 
+// LateResponseWait is the time the UDP client waits for the response to a
+// request that timed out so that it is not taken as the response to the next
+const LateResponseWait = 100 * time.Millisecond
+
 // UDPClient holds the UDP client connection
 type UDPClient struct {
 	*keystore.Sync              // Adopt the sync struct
@@ -56,6 +61,12 @@ func (udp *UDPClient) Connect() {
 			case request := <-udp.RequestChannel:
 				log.Println("Received a new client request")
 
+				// A request the caller has stopped waiting for is never sent
+				if err := request.Err(); err != nil {
+					deliver(request, &keystore.Response{Error: err.Error(), Code: keystore.CodeOf(err)})
+					continue
+				}
+
 				// Use gob to get a stream of bytes to write to a packet
 				var buff bytes.Buffer
 				if err := gob.NewEncoder(&buff).Encode(request); err != nil {
@@ -69,23 +80,36 @@ func (udp *UDPClient) Connect() {
 				}
 				log.Println("Waiting for client response")
 
-				// Wait for the response
-				buf := make([]byte, 1024)
+				// Wait for the response until the deadline of the request as a lost
+				// packet would otherwise never be answered
+				buf := make([]byte, MaxPacketSize)
+				udp.conn.SetReadDeadline(request.Deadline)
 				n, err := udp.conn.Read(buf)
 				if err != nil {
 					log.Println("Error whilst reading UDP response packet: ", err)
+					response := &keystore.Response{Error: err.Error(), Code: keystore.Unavailable}
+					expired := request.Err()
+					if expired != nil {
+						response = &keystore.Response{Error: expired.Error(), Code: keystore.CodeOf(expired)}
+					}
+					deliver(request, response)
+					if expired != nil {
+						udp.discard()
+					}
+					continue
 				}
 				response := &keystore.Response{}
 				if err := gob.NewDecoder(bytes.NewReader(buf[:n])).Decode(response); err != nil {
 					log.Printf("Error whilst reading UDP packet: %s", err)
 					if response.Error == "" {
 						response.Error = err.Error()
+						response.Code = keystore.Unavailable
 					}
 				}
 				log.Println("Received response from server")
 
 				// Send the response
-				go func() { request.ResponseChannel <- response }()
+				deliver(request, response)
 			case <-udp.quit:
 				log.Println("Client connection is shutting down")
 
@@ -97,6 +121,18 @@ func (udp *UDPClient) Connect() {
 	}()
 }
 
+// discard will read and discard any response that arrives late for a request
+// that timed out as the responses are not matched to their requests
+func (udp *UDPClient) discard() {
+	buf := make([]byte, MaxPacketSize)
+	for {
+		udp.conn.SetReadDeadline(time.Now().Add(LateResponseWait))
+		if _, err := udp.conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
 // Close will stop this client connection
 func (udp *UDPClient) Close() {
 
@@ -106,7 +142,8 @@ func (udp *UDPClient) Close() {
 	}()
 }
 
-// SendRequest will push the request onto the channel
+// SendRequest will push the request onto the channel without waiting for the
+// response. SendAsync will also return a future for the response
 func (udp *UDPClient) SendRequest(request *keystore.Request) {
 	// Spawn off the request to the channel
 	go func() {
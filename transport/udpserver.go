@@ -11,6 +11,9 @@ import (
 	"github.com/landonia/keystore"
 )
 
+// MaxPacketSize is the largest UDP packet that will be sent or received
+const MaxPacketSize = 65507
+
 // UDPServer holds the UDP client connection
 type UDPServer struct {
 	addr      string                   // the address to bind to
@@ -59,7 +62,7 @@ func (server *UDPServer) connect() {
 	server.connected = true
 
 	go func() {
-		buf := make([]byte, 1024)
+		buf := make([]byte, MaxPacketSize)
 		for {
 
 			// Collect the bytes from the socket
@@ -82,7 +85,7 @@ func (server *UDPServer) connect() {
 						log.Printf("Sending UDP error response to client [%s]", clientaddr)
 
 						// Handle the response
-						writeResponse(server.udpconn, client, &keystore.Response{Error: err.Error()})
+						writeResponse(server.udpconn, client, &keystore.Response{Error: err.Error(), Code: keystore.Invalid})
 					}()
 				} else {
 					log.Printf("Received UDP request from client: [%s]", clientaddr)
@@ -0,0 +1,147 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// The typed functions read and write the value of a key as a Go type so that
+// the callers do not have to assert the type of each value themselves. Any of
+// the integer kinds can be read from a whole number, including a float64 that
+// has been decoded from json, and any of the float kinds from any number. A
+// value that is not of the type, or does not fit within it, returns an error
+// matching ErrWrongType rather than panicking. Any other type is decoded in the
+// same way as GetValueType
+
+// Key is a handle for a key whose value is always of the type T, such as
+// keystore.Key[int]("hits")
+type Key[T any] string
+
+// GetAs returns the value of the key as the type T
+func GetAs[T any](store ContextKeyValueStore, key string) (T, error) {
+	return GetAsContext[T](context.Background(), store, key)
+}
+
+// GetAsContext returns the value of the key as the type T or an error once the
+// context is done
+func GetAsContext[T any](ctx context.Context, store ContextKeyValueStore, key string) (T, error) {
+	val, err := store.GetValueContext(ctx, key)
+	if err != nil {
+		var t T
+		return t, err
+	}
+	return As[T](val)
+}
+
+// SetAs will store the value of the type T for the key
+func SetAs[T any](store ContextKeyValueStore, key string, value T) error {
+	return SetAsWithTTLContext(context.Background(), store, key, value, 0)
+}
+
+// SetAsContext will store the value of the type T for the key or return an error
+// once the context is done
+func SetAsContext[T any](ctx context.Context, store ContextKeyValueStore, key string, value T) error {
+	return SetAsWithTTLContext(ctx, store, key, value, 0)
+}
+
+// SetAsWithTTL will store the value of the type T for the key that will expire
+// once the ttl has elapsed
+func SetAsWithTTL[T any](store ContextKeyValueStore, key string, value T, ttl time.Duration) error {
+	return SetAsWithTTLContext(context.Background(), store, key, value, ttl)
+}
+
+// SetAsWithTTLContext will store the value of the type T for the key that will
+// expire once the ttl has elapsed or return an error once the context is done.
+// The value is held in its canonical form so the type of the value held is the
+// type of that form, which is a FLOAT for any float kind and an INT for any
+// integer kind
+func SetAsWithTTLContext[T any](ctx context.Context, store ContextKeyValueStore, key string, value T, ttl time.Duration) error {
+	return store.SetValueWithTTLContext(ctx, key, value, ttl)
+}
+
+// As returns the value, such as a value returned by GetValue, MGet or a watch,
+// as the type T
+func As[T any](val interface{}) (T, error) {
+	var t T
+	if v, ok := val.(T); ok && val != nil {
+		return v, nil
+	}
+
+	// The numbers are converted so long as they fit within the type
+	target := reflect.ValueOf(&t).Elem()
+	ok := true
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int
+		if i, ok = toInt(val); ok && !target.OverflowInt(int64(i)) {
+			target.SetInt(int64(i))
+			return t, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var i int
+		if i, ok = toInt(val); ok && i >= 0 && !target.OverflowUint(uint64(i)) {
+			target.SetUint(uint64(i))
+			return t, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, ok = toFloat(val); ok && !target.OverflowFloat(f) {
+			target.SetFloat(f)
+			return t, nil
+		}
+	default:
+		if err := Decode(val, &t); err != nil {
+			return t, err
+		}
+		return t, nil
+	}
+	return t, generateError(WrongType, fmt.Sprintf("The value %v can not be held as the type %s", val, target.Type()))
+}
+
+// Name returns the name of the key
+func (k Key[T]) Name() string {
+	return string(k)
+}
+
+// Get returns the value of the key
+func (k Key[T]) Get(store ContextKeyValueStore) (T, error) {
+	return GetAsContext[T](context.Background(), store, string(k))
+}
+
+// GetContext returns the value of the key or an error once the context is done
+func (k Key[T]) GetContext(ctx context.Context, store ContextKeyValueStore) (T, error) {
+	return GetAsContext[T](ctx, store, string(k))
+}
+
+// Set will store the value for the key
+func (k Key[T]) Set(store ContextKeyValueStore, value T) error {
+	return SetAsWithTTLContext(context.Background(), store, string(k), value, 0)
+}
+
+// SetContext will store the value for the key or return an error once the
+// context is done
+func (k Key[T]) SetContext(ctx context.Context, store ContextKeyValueStore, value T) error {
+	return SetAsWithTTLContext(ctx, store, string(k), value, 0)
+}
+
+// SetWithTTL will store the value for the key that will expire once the ttl
+// has elapsed
+func (k Key[T]) SetWithTTL(store ContextKeyValueStore, value T, ttl time.Duration) error {
+	return SetAsWithTTLContext(context.Background(), store, string(k), value, ttl)
+}
+
+// SetWithTTLContext will store the value for the key that will expire once the
+// ttl has elapsed or return an error once the context is done
+func (k Key[T]) SetWithTTLContext(ctx context.Context, store ContextKeyValueStore, value T, ttl time.Duration) error {
+	return SetAsWithTTLContext(ctx, store, string(k), value, ttl)
+}
+
+// Delete will delete the key and value from the store
+func (k Key[T]) Delete(store ContextKeyValueStore) error {
+	return store.DeleteKeyContext(context.Background(), string(k))
+}
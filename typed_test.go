@@ -0,0 +1,88 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// expectAs will check that the value is returned as the type T by As
+func expectAs[T any](t *testing.T, val interface{}, expected T) {
+	t.Helper()
+	got, err := As[T](val)
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Errorf("As[%T](%#v) = %#v, %v, expected %#v", expected, val, got, err, expected)
+	}
+}
+
+// expectWrongType will check that the value can not be returned as the type T
+func expectWrongType[T any](t *testing.T, val interface{}) {
+	t.Helper()
+	if got, err := As[T](val); !errors.Is(err, ErrWrongType) {
+		t.Errorf("As[%T](%#v) = %#v, %v, expected ErrWrongType", got, val, got, err)
+	}
+}
+
+func TestAsConvertsNumbers(t *testing.T) {
+	expectAs(t, 42, 42)
+	expectAs(t, 42.0, 42)
+	expectAs(t, 42, int8(42))
+	expectAs(t, 255, uint8(255))
+	expectAs(t, 42, 42.0)
+	expectAs(t, 2.5, float32(2.5))
+	expectAs[interface{}](t, 42, 42)
+
+	expectWrongType[int](t, 2.5)
+	expectWrongType[int8](t, 128)
+	expectWrongType[uint](t, -1)
+	expectWrongType[uint8](t, 256)
+	expectWrongType[float32](t, math.MaxFloat64)
+	expectWrongType[int](t, "42")
+	expectWrongType[float64](t, nil)
+}
+
+func TestAsDecodesOtherTypes(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	expectAs(t, map[string]interface{}{"Name": "landon", "Age": 30}, user{Name: "landon", Age: 30})
+	expectAs(t, []interface{}{"a", "b"}, []string{"a", "b"})
+	expectAs(t, []interface{}{1, 2.0}, []float64{1, 2})
+	expectAs(t, `{"Name":"landon"}`, user{Name: "landon"})
+	expectAs(t, "value", "value")
+	expectAs(t, Set{"a", "b"}, []string{"a", "b"})
+
+	expectWrongType[user](t, 42)
+	expectWrongType[bool](t, 1)
+}
+
+func TestKeyHandle(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+
+	hits := Key[uint16]("hits")
+	if err := hits.Set(ks, 5); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if val, err := ks.GetInt("hits"); err != nil || val != 5 {
+		t.Errorf("GetInt(hits) = %v, %v, expected the value to be held as an INT", val, err)
+	}
+	if _, err := ks.IncrInt("hits", math.MaxUint16); err != nil {
+		t.Fatalf("IncrInt: %s", err)
+	}
+	if val, err := hits.Get(ks); !errors.Is(err, ErrWrongType) {
+		t.Errorf("Get() = %d, %v, expected the value that does not fit to be the wrong type", val, err)
+	}
+	if err := hits.Delete(ks); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if _, err := hits.Get(ks); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete returned %v, expected ErrNotFound", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every value is held by the store in a canonical form so that a value written
+// through the store, the service or any of the transports is read back the same
+// through any other. The canonical form is the json data model: a bool, string,
+// []interface{} or map[string]interface{}, with every number held as an int or
+// a float64 wherever it is within the value. A float64 is always encoded as json
+// with a fraction or exponent (3.0 rather than 3) so that it is decoded as a
+// float64 again while a number without either is decoded as an int. A value of
+// any other Go type, such as a struct, is converted to the same form it would
+// have once encoded and decoded as json
+
+// Normalize returns the value in the canonical form held by the store
+func Normalize(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, bool, int, float64, string, Set, ZSet:
+		return v, nil
+	case int8, int16, int32, int64:
+		return int(reflect.ValueOf(v).Int()), nil
+	case uint, uint8, uint16, uint32, uint64:
+		u := reflect.ValueOf(v).Uint()
+		if u > math.MaxInt64 {
+			return nil, generateError(Invalid, fmt.Sprintf("The value %d is too large to be held as an int", u))
+		}
+		return int(u), nil
+	case float32:
+		return float64(v), nil
+	case []interface{}:
+		return normalizeItems(v)
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(v))
+		for field, item := range v {
+			var err error
+			if fields[field], err = normalizeItem(item); err != nil {
+				return nil, err
+			}
+		}
+		return fields, nil
+	}
+
+	// Any other type is converted using its json encoding
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, generateError(Invalid, fmt.Sprintf("The value can not be encoded: %s", err))
+	}
+	return UnmarshalValue(b)
+}
+
+// normalizeItem returns the item of an array or map in the canonical form
+func normalizeItem(item interface{}) (interface{}, error) {
+	switch v := item.(type) {
+	case float32:
+		return float64(v), nil
+	case Set, ZSet:
+		// The sets are only held as the value of a key
+		return UnmarshalValue(mustMarshal(v))
+	}
+	return Normalize(item)
+}
+
+// normalizeItems returns the items of a list in the canonical form
+func normalizeItems(items []interface{}) ([]interface{}, error) {
+	normalized := make([]interface{}, len(items))
+	for i, item := range items {
+		var err error
+		if normalized[i], err = normalizeItem(item); err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+// equalValues returns true if the value is the same as the value held by the
+// store once it is in the canonical form for the type of the value held
+func equalValues(held, value interface{}) bool {
+	val, err := Normalize(value)
+	if err != nil {
+		return false
+	}
+	if converted, err := ConvertValue(TypeOf(held), val); err == nil {
+		val = converted
+	}
+	return reflect.DeepEqual(held, val)
+}
+
+// mustMarshal returns the json encoding of a value that is known to encode
+func mustMarshal(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+// MarshalValue returns the json encoding of the canonical value. Each float64
+// within it is written with a fraction or exponent so that it is decoded as a
+// float64 rather than an int
+func MarshalValue(val interface{}) ([]byte, error) {
+	return json.Marshal(withFloats(val))
+}
+
+// withFloats returns the value with each float64 replaced by the json number it
+// is written as. Arrays and maps are copied rather than changed
+func withFloats(val interface{}) interface{} {
+	switch v := val.(type) {
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return v
+		}
+		n := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(n, ".e") {
+			n += ".0"
+		}
+		return json.Number(n)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = withFloats(item)
+		}
+		return items
+	case map[string]interface{}:
+		fields := make(map[string]interface{}, len(v))
+		for field, item := range v {
+			fields[field] = withFloats(item)
+		}
+		return fields
+	}
+	return val
+}
+
+// UnmarshalValue will decode the json in to the canonical form of the value
+func UnmarshalValue(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var val interface{}
+	if err := decoder.Decode(&val); err != nil {
+		return nil, generateError(Invalid, fmt.Sprintf("The value is not valid json: %s", err))
+	}
+	return canonical(val), nil
+}
+
+// canonical replaces each of the json numbers within the decoded value with a
+// float64 if it has a fraction or exponent, or is too large for an int, and an
+// int otherwise
+func canonical(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				return int(i)
+			}
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, item := range v {
+			v[i] = canonical(item)
+		}
+	case map[string]interface{}:
+		for field, item := range v {
+			v[field] = canonical(item)
+		}
+	}
+	return val
+}
+
+// ConvertValue returns the canonical value as the Go type held by the store for
+// the data type. A whole number is accepted as a FLOAT and a float64 that is a
+// whole number as an INT, while the sets are accepted in their json form.
+// ErrWrongType is returned if the value can not be converted
+func ConvertValue(dType Type, val interface{}) (interface{}, error) {
+	ok := true
+	switch dType {
+	case BOOL:
+		_, ok = val.(bool)
+	case INT:
+		val, ok = toInt(val)
+	case FLOAT:
+		val, ok = toFloat(val)
+	case STRING:
+		_, ok = val.(string)
+	case ARRAY:
+		_, ok = val.([]interface{})
+	case MAP:
+		_, ok = val.(map[string]interface{})
+	case SET:
+		val, ok = toSet(val)
+	case ZSET:
+		val, ok = toZSet(val)
+	}
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return val, nil
+}
+
+// decodeValue will decode the json as the type it was written with
+func decodeValue(dType Type, data []byte) (interface{}, error) {
+	val, err := UnmarshalValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertValue(dType, val)
+}
+
+// Decode will decode the value in to v, which must be a pointer, as if the value
+// had been encoded as json and then decoded with json.Unmarshal. A string holding
+// json is also decoded as the value that it holds, as that is how the clients
+// wrote values of any other type before they were held in the canonical form
+func Decode(value interface{}, v interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return generateError(Invalid, fmt.Sprintf("The value can not be encoded: %s", err))
+	}
+	err = json.Unmarshal(b, v)
+	if s, ok := value.(string); ok && err != nil {
+		if json.Unmarshal([]byte(s), v) == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		return generateError(WrongType, fmt.Sprintf("The value can not be decoded: %s", err))
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler so that the floats within the value are
+// decoded as floats again by UnmarshalJSON
+func (holder ValueHolder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type Type
+		Val  interface{}
+		TTL  int64
+	}{holder.Type, withFloats(holder.Val), int64(holder.TTL)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler so that the value is decoded in to
+// the canonical form for its type rather than the default json types
+func (holder *ValueHolder) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type Type
+		Val  json.RawMessage
+		TTL  int64
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	holder.Type, holder.TTL, holder.Val = raw.Type, time.Duration(raw.TTL), nil
+	if len(raw.Val) == 0 || string(raw.Val) == "null" {
+		return nil
+	}
+	val, err := UnmarshalValue(raw.Val)
+	if err != nil {
+		return err
+	}
+
+	// The value is kept in its canonical form if it is not of the type given
+	if converted, err := ConvertValue(raw.Type, val); err == nil {
+		val = converted
+	}
+	holder.Val = val
+	return nil
+}
@@ -0,0 +1,57 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeKeepsNumberTypes(t *testing.T) {
+	type point struct {
+		X int
+		Y float64
+	}
+	tests := map[string]struct {
+		value    interface{}
+		expected interface{}
+	}{
+		"float":        {3.0, 3.0},
+		"float32":      {float32(1.5), 1.5},
+		"int64":        {int64(3), 3},
+		"nested float": {[]interface{}{1, 3.0, float32(2)}, []interface{}{1, 3.0, 2.0}},
+		"nested map":   {map[string]interface{}{"a": 3.0, "b": []interface{}{4}}, map[string]interface{}{"a": 3.0, "b": []interface{}{4}}},
+		"struct":       {point{X: 1, Y: 2.5}, map[string]interface{}{"X": 1, "Y": 2.5}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			val, err := Normalize(test.value)
+			if err != nil || !reflect.DeepEqual(val, test.expected) {
+				t.Fatalf("Normalize(%#v) = %#v, %v, expected %#v", test.value, val, err, test.expected)
+			}
+
+			// The value is the same once it has been encoded and decoded as json
+			b, err := MarshalValue(val)
+			if err != nil {
+				t.Fatalf("MarshalValue(%#v): %s", val, err)
+			}
+			if decoded, err := UnmarshalValue(b); err != nil || !reflect.DeepEqual(decoded, val) {
+				t.Errorf("UnmarshalValue(%s) = %#v, %v, expected %#v", b, decoded, err, val)
+			}
+		})
+	}
+}
+
+func TestValueHolderJSON(t *testing.T) {
+	holder := &ValueHolder{Type: ARRAY, Val: []interface{}{1, 2.0, map[string]interface{}{"a": 1e21}}}
+	b, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &ValueHolder{}
+	if err := json.Unmarshal(b, decoded); err != nil || !reflect.DeepEqual(decoded, holder) {
+		t.Errorf("Decoded %s as %#v, %v, expected %#v", b, decoded, err, holder)
+	}
+}
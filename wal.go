@@ -0,0 +1,269 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy determines how often the write ahead log is flushed to disk
+type SyncPolicy uint
+
+// The policies available for flushing the write ahead log
+const (
+	SyncAlways   SyncPolicy = iota // Every entry is flushed to disk as it is written
+	SyncInterval                   // The log is flushed to disk periodically
+	SyncNever                      // The log is never flushed and is left to the OS
+)
+
+// DefaultCompactionSize is the size in bytes that the log can grow to before
+// it is compacted into a new snapshot
+const DefaultCompactionSize int64 = 64 * 1024 * 1024
+
+// logEntry is a single operation that is appended to the write ahead log.
+// Write operations include the record holding the new value and transactions
+// hold each of the entries that must be applied together
+type logEntry struct {
+	Op      Op
+	Key     string      `json:",omitempty"`
+	Entries []*logEntry `json:",omitempty"`
+	record
+}
+
+// writeAheadLog is an append only log of the operations applied to the store
+// since the last snapshot was written to disk
+type writeAheadLog struct {
+	file   *os.File   // The open log file
+	policy SyncPolicy // When the log is flushed to disk
+	size   int64      // The current size of the log
+	dirty  bool       // Whether there are entries that have not been flushed
+	mutex  sync.Mutex // Guards the log as it is shared by the shards of the store
+}
+
+// logPath returns the path of the write ahead log for the store
+func (s *Store) logPath() string {
+	return s.filePath + ".wal"
+}
+
+// UpdateSyncPolicy will change how often the write ahead log is flushed to disk
+func (s *Store) UpdateSyncPolicy(policy SyncPolicy) {
+	s.syncPolicy = policy
+	if s.wal != nil {
+		s.wal.policy = policy
+	}
+}
+
+// UpdateCompactionSize will change the size in bytes the write ahead log can
+// grow to before it is compacted into a new snapshot
+func (s *Store) UpdateCompactionSize(size int64) {
+	s.compactionSize = size
+}
+
+// replayLog will apply every entry in the write ahead log to the store and then
+// open the log ready for new entries to be appended
+func (s *Store) replayLog() error {
+	f, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Apply each of the entries in order. A crash whilst an entry was being written
+	// will leave a partial entry at the end which is discarded
+	decoder := json.NewDecoder(f)
+	now := time.Now()
+	var count int
+	for {
+		entry := logEntry{}
+		if err = decoder.Decode(&entry); err != nil {
+			if err != io.EOF {
+				log.Printf("Discarding partial entry at the end of the log: %s", err)
+			}
+			break
+		}
+		s.applyLogEntry(&entry, now)
+		count++
+	}
+	if count > 0 {
+		log.Printf("Replayed %d entries from the log %s", count, s.logPath())
+	}
+
+	// Anything after the last complete entry is removed so new entries follow on
+	size := decoder.InputOffset()
+	if err = f.Truncate(size); err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.wal = &writeAheadLog{file: f, policy: s.syncPolicy, size: size}
+	return nil
+}
+
+// applyLogEntry will apply the operation from the log to the store
+func (s *Store) applyLogEntry(entry *logEntry, now time.Time) {
+	switch entry.Op {
+	case WRITE:
+		val, err := entry.value()
+		if err != nil {
+			log.Printf("Error decoding log entry for key '%s': %s", entry.Key, err)
+		} else if entry.Expires == nil {
+			s.SetValue(entry.Key, val)
+		} else if now.Before(*entry.Expires) {
+			s.SetValueWithTTL(entry.Key, val, entry.Expires.Sub(now))
+		} else {
+			s.DeleteKey(entry.Key)
+		}
+	case DELETE:
+		s.DeleteKey(entry.Key)
+	case TRANSACTION:
+		for _, child := range entry.Entries {
+			s.applyLogEntry(child, now)
+		}
+	}
+
+	// The key is given the version it had when the entry was written
+	if entry.Version > 0 {
+		if _, exists := s.values[entry.Key]; exists {
+			s.versions[entry.Key] = entry.Version
+		}
+		s.sequence.advance(entry.Version)
+	}
+}
+
+// logChanges will append the current state of each of the keys to the write
+// ahead log. Multiple keys are written as a single entry so that they are
+// either all replayed or none are
+func (s *Store) logChanges(keys ...string) {
+	s.appendEntries(s.logEntries(keys...))
+}
+
+// logEntries returns the entries holding the current state of each of the keys
+func (s *Store) logEntries(keys ...string) []*logEntry {
+	entries := make([]*logEntry, 0, len(keys))
+	for _, key := range keys {
+		if !s.KeyExists(key) {
+			entries = append(entries, &logEntry{Op: DELETE, Key: key, record: record{Version: s.sequence.current()}})
+			continue
+		}
+		r, err := s.newRecord(key)
+		if err != nil {
+			log.Printf("Error encoding log entry for key '%s': %s", key, err)
+			continue
+		}
+		entries = append(entries, &logEntry{Op: WRITE, Key: key, record: *r})
+	}
+	return entries
+}
+
+// appendEntries will append the entries to the log as a single entry
+func (s *Store) appendEntries(entries []*logEntry) {
+	if len(entries) == 1 {
+		s.appendLog(entries[0])
+	} else if len(entries) > 1 {
+		s.appendLog(&logEntry{Op: TRANSACTION, Entries: entries})
+	}
+}
+
+// appendLog will write the entry to the end of the log. Once the log has grown
+// beyond the compaction size it is compacted into a new snapshot
+func (s *Store) appendLog(entry *logEntry) {
+	if s.wal == nil {
+		return
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding log entry for key '%s': %s", entry.Key, err)
+		return
+	}
+	s.wal.mutex.Lock()
+	n, err := s.wal.file.Write(append(b, '\n'))
+	s.wal.size += int64(n)
+	if err == nil {
+		s.wal.dirty = true
+		if s.wal.policy == SyncAlways {
+			err = s.wal.sync()
+		}
+	}
+	s.wal.mutex.Unlock()
+	if err != nil {
+		log.Printf("Error writing log entry for key '%s': %s", entry.Key, err)
+		return
+	}
+	if s.compactionDue() {
+		log.Printf("Compacting the log %s", s.logPath())
+		if err = s.SaveToDisk(); err != nil {
+			log.Printf("Error compacting the log: %s", err)
+		}
+	}
+}
+
+// compactionDue returns true once the log has grown beyond the compaction size
+func (s *Store) compactionDue() bool {
+	if s.wal == nil || s.compactionSize <= 0 {
+		return false
+	}
+	s.wal.mutex.Lock()
+	defer s.wal.mutex.Unlock()
+	return s.wal.size >= s.compactionSize
+}
+
+// SyncLog will flush any entries in the write ahead log to disk
+func (s *Store) SyncLog() error {
+	if s.wal == nil {
+		return nil
+	}
+	s.wal.mutex.Lock()
+	defer s.wal.mutex.Unlock()
+	return s.wal.sync()
+}
+
+// sync will flush the log to disk if there are any entries that have not been
+// flushed. The mutex must be held
+func (l *writeAheadLog) sync() error {
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+// truncateLog will remove all the entries from the log once they have been
+// written to a snapshot
+func (s *Store) truncateLog() error {
+	if s.wal == nil {
+		return nil
+	}
+	s.wal.mutex.Lock()
+	defer s.wal.mutex.Unlock()
+	if err := s.wal.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.wal.size = 0
+	s.wal.dirty = false
+	return s.wal.file.Sync()
+}
+
+// Close will flush and close the write ahead log
+func (s *Store) Close() error {
+	if s.wal == nil {
+		return nil
+	}
+	s.wal.mutex.Lock()
+	defer s.wal.mutex.Unlock()
+	err := s.wal.sync()
+	if cerr := s.wal.file.Close(); err == nil {
+		err = cerr
+	}
+	s.wal = nil
+	return err
+}
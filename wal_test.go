@@ -0,0 +1,103 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openStore will read the store at the path from disk, which opens its write
+// ahead log. The log is closed once the test has finished
+func openStore(t *testing.T, path string) *Store {
+	t.Helper()
+	s := NewStoreFromFile(path)
+	if err := s.ReadFromDisk(); err != nil {
+		t.Fatalf("ReadFromDisk: %s", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestReplayLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openStore(t, path)
+	s.SetValue("int", 1)
+	s.SetValueWithTTL("ttl", "expires", time.Hour)
+	s.SetValue("deleted", true)
+	s.logChanges("int", "ttl")
+	s.logChanges("deleted")
+	s.DeleteKey("deleted")
+	s.logChanges("deleted")
+	s.Close()
+
+	replayed := openStore(t, path)
+	if val, err := replayed.GetValue("int"); err != nil || val != 1 {
+		t.Errorf("GetValue(int) = %v, %v, expected 1", val, err)
+	}
+	if replayed.Version("int") != s.Version("int") {
+		t.Errorf("Version(int) = %d, expected %d", replayed.Version("int"), s.Version("int"))
+	}
+	if ttl, err := replayed.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(ttl) = %s, %v, expected the time left from the log", ttl, err)
+	}
+	if replayed.KeyExists("deleted") {
+		t.Error("The deleted key was restored from the log")
+	}
+}
+
+func TestReplayLogAfterPartialEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openStore(t, path)
+	s.SetValue("a", map[string]interface{}{"b": 2})
+	s.logChanges("a")
+	s.Close()
+
+	// A crash whilst an entry was being written leaves part of it at the end
+	f, err := os.OpenFile(s.logPath(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"Op":2,"Key":"partial","Ty`)
+	f.Close()
+
+	replayed := openStore(t, path)
+	if val, err := replayed.GetValue("a"); err != nil || !reflect.DeepEqual(val, map[string]interface{}{"b": 2}) {
+		t.Errorf("GetValue(a) = %#v, %v, expected the value from the log", val, err)
+	}
+	if replayed.KeyExists("partial") {
+		t.Error("The partial entry was replayed")
+	}
+
+	// The partial entry is discarded so the next entry can still be replayed
+	replayed.SetValue("c", "after")
+	replayed.logChanges("c")
+	replayed.Close()
+	if !openStore(t, path).KeyExists("c") {
+		t.Error("The entry written after the partial entry was not replayed")
+	}
+}
+
+func TestCompactLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store")
+	s := openStore(t, path)
+	s.UpdateCompactionSize(1)
+	s.SetValue("key", "value")
+	s.logChanges("key")
+
+	// Once the log is too big it is written to a snapshot and emptied
+	if info, err := os.Stat(s.logPath()); err != nil || info.Size() != 0 {
+		t.Fatalf("The log was not emptied once it reached the compaction size: %v", err)
+	}
+	if err := NewEmptyStore().loadSnapshot(path); err != nil {
+		t.Fatalf("The log was not compacted into a snapshot: %s", err)
+	}
+	s.Close()
+	if val, err := openStore(t, path).GetValue("key"); err != nil || val != "value" {
+		t.Errorf("GetValue(key) = %v, %v after the compaction, expected value", val, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// DefaultWatchBuffer is the number of events that can be waiting to be read
+// from a watch. A watch that falls this far behind is closed rather than
+// holding up the service
+const DefaultWatchBuffer = 256
+
+// WatchOptions determines which of the keys are watched. A key is watched if
+// it is one of the keys or begins with one of the prefixes. Every key is
+// watched if no keys or prefixes are given
+type WatchOptions struct {
+	Keys     []string // The exact keys that are watched
+	Prefixes []string // The keys beginning with any of the prefixes are watched
+}
+
+// Event is sent to a watch for every change to a key that it is watching
+type Event struct {
+	Op      Op           // WRITE if the key has a new value or DELETE if it has been removed
+	Key     string       // The key that has changed
+	Value   *ValueHolder // The new value of the key (nil if it has been removed)
+	Version uint64       // The version of the key after the change
+}
+
+// watcher holds the events channel of a watch along with the keys it is watching
+type watcher struct {
+	id      uint64
+	options WatchOptions
+	keys    map[string]bool
+	events  chan *Event
+}
+
+// NewWatchRequest will generate a new Request for watching the keys. The events
+// are sent on the channel provided which is closed once the watch has ended
+func NewWatchRequest(options WatchOptions, events chan *Event) *Request {
+	return &Request{Op: WATCH, Watch: &options, Events: events, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// NewUnwatchRequest will generate a new Request for ending the watch
+func NewUnwatchRequest(id uint64) *Request {
+	return &Request{Op: UNWATCH, Subscription: id, Value: &ValueHolder{Type: NONE}, ResponseChannel: make(chan *Response)}
+}
+
+// matches returns true if the key is being watched
+func (w *watcher) matches(key string) bool {
+	if len(w.keys) == 0 && len(w.options.Prefixes) == 0 {
+		return true
+	}
+	if w.keys[key] {
+		return true
+	}
+	for _, prefix := range w.options.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// event returns the event for the current state of the key
+func (s *Store) event(key string) *Event {
+	val, exists := s.values[key]
+	if !exists {
+		return &Event{Op: DELETE, Key: key, Version: s.sequence.current()}
+	}
+
+	// The list is limited to its length as it may be pushed to in place
+	if list, ok := val.([]interface{}); ok {
+		val = list[:len(list):len(list)]
+	}
+	return &Event{Op: WRITE, Key: key, Value: &ValueHolder{Type: TypeOf(val), Val: val}, Version: s.versions[key]}
+}
+
+// watch will start sending the events for the keys of the request
+func (ks *Service) watch(request *Request, response *Response) {
+	if request.Events == nil {
+		response.fail(generateError(Invalid, "A channel is required to receive the events of a watch"))
+		return
+	}
+	ks.lastSubscription++
+	w := &watcher{id: ks.lastSubscription, events: request.Events, keys: make(map[string]bool)}
+	if request.Watch != nil {
+		w.options = *request.Watch
+		for _, key := range w.options.Keys {
+			w.keys[key] = true
+		}
+	}
+	ks.watchMutex.Lock()
+	ks.watchers[w.id] = w
+	ks.watchMutex.Unlock()
+	response.Subscription = w.id
+	response.Success = true
+}
+
+// unwatch will end the watch of the request
+func (ks *Service) unwatch(request *Request, response *Response) {
+	ks.watchMutex.Lock()
+	defer ks.watchMutex.Unlock()
+	if !ks.closeWatch(request.Subscription) {
+		response.fail(generateError(NotFound, fmt.Sprintf("The watch %d does not exist", request.Subscription)))
+		return
+	}
+	response.Success = true
+}
+
+// closeWatch will stop sending events to the watch and close its channel. The
+// mutex must be held
+func (ks *Service) closeWatch(id uint64) bool {
+	w, ok := ks.watchers[id]
+	if ok {
+		delete(ks.watchers, id)
+		close(w.events)
+	}
+	return ok
+}
+
+// notify will send an event to each of the watches for the changes to the keys.
+// The service will never wait for a watch so any watch that has not read its
+// previous events is closed. Each event is read from the shard holding the key
+// which must either be the shard calling or be paused
+func (ks *Service) notify(keys ...string) {
+	ks.watchMutex.Lock()
+	defer ks.watchMutex.Unlock()
+	if len(ks.watchers) == 0 {
+		return
+	}
+	for _, key := range keys {
+		event := ks.shardFor(key).store.event(key)
+		for id, w := range ks.watchers {
+			if !w.matches(key) {
+				continue
+			}
+			select {
+			case w.events <- event:
+			default:
+				log.Printf("Closing watch %d as it is not reading the events", id)
+				ks.closeWatch(id)
+			}
+		}
+	}
+}
+
+// stopWatchers will close every watch as the service is stopping
+func (ks *Service) stopWatchers() {
+	ks.watchMutex.Lock()
+	defer ks.watchMutex.Unlock()
+	for id := range ks.watchers {
+		ks.closeWatch(id)
+	}
+}
@@ -0,0 +1,86 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of the watch, or nil once it has closed
+func nextEvent(t *testing.T, events <-chan *Event) *Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("No event was received from the watch")
+	}
+	return nil
+}
+
+func TestWatchEvents(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	defer func() { <-ks.Stop() }()
+	_, events, err := ks.Watch(WatchOptions{Keys: []string{"config"}, Prefixes: []string{"user."}})
+	if err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	ks.SetInt("other", 1)
+	ks.SetInt("user.a", 1)
+	ks.SetString("config", "value")
+	ks.DeleteKey("user.a")
+
+	expected := []Event{{Op: WRITE, Key: "user.a"}, {Op: WRITE, Key: "config"}, {Op: DELETE, Key: "user.a"}}
+	for _, e := range expected {
+		event := nextEvent(t, events)
+		if event == nil || event.Op != e.Op || event.Key != e.Key {
+			t.Fatalf("Received the event %+v, expected %+v", event, e)
+		}
+		if e.Op == WRITE && (event.Value == nil || event.Version == 0) {
+			t.Errorf("The event for %s does not hold the value and version", event.Key)
+		}
+	}
+}
+
+func TestWatchTeardown(t *testing.T) {
+	ks := NewService("")
+	ks.Start()
+	id, events, err := ks.Watch(WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	if err = ks.Unwatch(id); err != nil {
+		t.Fatalf("Unwatch: %s", err)
+	}
+	if event := nextEvent(t, events); event != nil {
+		t.Errorf("Received %+v, expected the channel to be closed by Unwatch", event)
+	}
+	if err = ks.Unwatch(id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unwatch of an ended watch returned %v, expected it not to be found", err)
+	}
+
+	// A watch that does not read its events is closed rather than holding up the store
+	slow := make(chan *Event, 1)
+	if _, err = ks.SendAsync(NewWatchRequest(WatchOptions{}, slow)).Wait(); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	ks.SetInt("a", 1)
+	ks.SetInt("b", 1)
+	if event := nextEvent(t, slow); event == nil || event.Key != "a" {
+		t.Errorf("Received %+v, expected the event that was buffered", event)
+	}
+	if event := nextEvent(t, slow); event != nil {
+		t.Errorf("Received %+v, expected the slow watch to be closed", event)
+	}
+
+	// Every watch is closed once the service stops
+	_, events, _ = ks.Watch(WatchOptions{})
+	<-ks.Stop()
+	if event := nextEvent(t, events); event != nil {
+		t.Errorf("Received %+v, expected the watch to be closed when the service stopped", event)
+	}
+}
@@ -0,0 +1,351 @@
+// Landon Wainwright.
+
+// Package keystore provides an in memory key/value store service library
+package keystore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member string  // The unique member
+	Score  float64 // The score used to order the member
+}
+
+// ZSet is a ZSET value of unique members ordered by their score (and then by the
+// member for equal scores). As with the other values a sorted set is never changed
+// in place, a new sorted set is created for each change
+type ZSet []ZMember
+
+// NewZSet creates a sorted set of the members. If a member is given more than once
+// the last score is used
+func NewZSet(members ...ZMember) ZSet {
+	scores := make(map[string]float64, len(members))
+	for _, m := range members {
+		scores[m.Member] = m.Score
+	}
+	return newZSetFromScores(scores)
+}
+
+// newZSetFromScores creates the sorted set from the score of each member
+func newZSetFromScores(scores map[string]float64) ZSet {
+	zset := make(ZSet, 0, len(scores))
+	for member, score := range scores {
+		zset = append(zset, ZMember{member, score})
+	}
+	sort.Slice(zset, func(i, j int) bool {
+		if zset[i].Score != zset[j].Score {
+			return zset[i].Score < zset[j].Score
+		}
+		return zset[i].Member < zset[j].Member
+	})
+	return zset
+}
+
+// Rank returns the position of the member within the sorted set or -1 if it is
+// not a member
+func (zset ZSet) Rank(member string) int {
+	for i := range zset {
+		if zset[i].Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// Score returns the score of the member and whether it is a member
+func (zset ZSet) Score(member string) (float64, bool) {
+	if i := zset.Rank(member); i >= 0 {
+		return zset[i].Score, true
+	}
+	return 0, false
+}
+
+// RangeByScore returns the members with a score from min to max (inclusive)
+func (zset ZSet) RangeByScore(min, max float64) ZSet {
+	start := sort.Search(len(zset), func(i int) bool { return zset[i].Score >= min })
+	stop := sort.Search(len(zset), func(i int) bool { return zset[i].Score > max })
+	if start >= stop {
+		return ZSet{}
+	}
+	return zset[start:stop:stop]
+}
+
+// update returns a new sorted set with the scores of the members changed
+// and the number of members that were added
+func (zset ZSet) update(members ...ZMember) (ZSet, int) {
+	scores := make(map[string]float64, len(zset)+len(members))
+	for _, m := range zset {
+		scores[m.Member] = m.Score
+	}
+	var added int
+	for _, m := range members {
+		if _, exists := scores[m.Member]; !exists {
+			added++
+		}
+		scores[m.Member] = m.Score
+	}
+	return newZSetFromScores(scores), added
+}
+
+// toZSet returns the value as a sorted set. A sorted set that has been decoded
+// from json will be an array of objects holding the member and score, and a map
+// of each member to its score is also accepted
+func toZSet(val interface{}) (ZSet, bool) {
+	switch v := val.(type) {
+	case ZSet:
+		return NewZSet(v...), true
+	case []ZMember:
+		return NewZSet(v...), true
+	case map[string]float64:
+		return newZSetFromScores(v), true
+	case map[string]interface{}:
+		scores := make(map[string]float64, len(v))
+		for member, score := range v {
+			var ok bool
+			if scores[member], ok = toFloat(score); !ok {
+				return nil, false
+			}
+		}
+		return newZSetFromScores(scores), true
+	case []interface{}:
+		members := make([]ZMember, len(v))
+		for i, raw := range v {
+			m, ok := raw.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			member, memberOk := m["Member"].(string)
+			score, scoreOk := toFloat(m["Score"])
+			if !memberOk || !scoreOk {
+				return nil, false
+			}
+			members[i] = ZMember{member, score}
+		}
+		return NewZSet(members...), true
+	}
+	return nil, false
+}
+
+// NewZAddRequest will generate a new Request for adding the members to the sorted
+// set or changing the score of those that are already members
+func NewZAddRequest(key string, members ...ZMember) *Request {
+	return &Request{Op: ZADD, Key: key, Value: &ValueHolder{Type: ZSET, Val: ZSet(members)}, ResponseChannel: make(chan *Response)}
+}
+
+// NewZRemRequest will generate a new Request for removing the members of the sorted set
+func NewZRemRequest(key string, members ...string) *Request {
+	return &Request{Op: ZREM, Key: key, Value: &ValueHolder{Type: SET, Val: members}, ResponseChannel: make(chan *Response)}
+}
+
+// NewZMemberRequest will generate a new Request for reading the score (ZSCORE) or
+// rank (ZRANK) of the member of the sorted set
+func NewZMemberRequest(op Op, key, member string) *Request {
+	return &Request{Op: op, Key: key, Value: &ValueHolder{Type: NONE, Val: member}, ResponseChannel: make(chan *Response)}
+}
+
+// NewZRangeRequest will generate a new Request for reading the members of the
+// sorted set from the start to stop rank (inclusive). Negative ranks are counted
+// from the end of the sorted set
+func NewZRangeRequest(key string, start, stop int) *Request {
+	return &Request{Op: ZRANGE, Key: key, Value: &ValueHolder{Type: ZSET}, Start: start, Stop: stop, ResponseChannel: make(chan *Response)}
+}
+
+// NewZRangeByScoreRequest will generate a new Request for reading the members of
+// the sorted set with a score from min to max (inclusive)
+func NewZRangeByScoreRequest(key string, min, max float64) *Request {
+	return &Request{Op: ZRANGEBYSCORE, Key: key, Value: &ValueHolder{Type: ZSET}, Min: min, Max: max, ResponseChannel: make(chan *Response)}
+}
+
+// NewZIncrByRequest will generate a new Request for adding the delta to the score
+// of the member of the sorted set
+func NewZIncrByRequest(key, member string, delta float64) *Request {
+	return &Request{Op: ZINCRBY, Key: key, Value: &ValueHolder{Type: FLOAT, Val: ZMember{member, delta}}, ResponseChannel: make(chan *Response)}
+}
+
+// getZSet returns the sorted set for the key. A key that does not exist is an
+// empty sorted set
+func (s *Store) getZSet(key string) (ZSet, error) {
+	raw, exists := s.access(key)
+	if !exists {
+		return ZSet{}, nil
+	}
+	zset, ok := raw.(ZSet)
+	if !ok {
+		return nil, generateTypeError(key)
+	}
+	return zset, nil
+}
+
+// generateNotMemberError will generate an error for a member that is not in the sorted set
+func generateNotMemberError(key, member string) error {
+	return generateError(NotFound, fmt.Sprintf("The member '%s' does not exist for key '%s'", member, key))
+}
+
+// ZAdd will add the members to the sorted set (or change the score of those that
+// are already members) returning the number that were added. A key that does not
+// exist is created
+func (s *Store) ZAdd(key string, members ...ZMember) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil || len(members) == 0 {
+		return 0, err
+	}
+	updated, added := zset.update(members...)
+	s.updateValue(key, updated)
+	return added, nil
+}
+
+// ZRem will remove the members from the sorted set returning the number that were removed
+func (s *Store) ZRem(key string, members ...string) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	remove := NewSet(members...)
+	updated := make(ZSet, 0, len(zset))
+	for _, m := range zset {
+		if !remove.Contains(m.Member) {
+			updated = append(updated, m)
+		}
+	}
+	removed := len(zset) - len(updated)
+	if removed > 0 {
+		s.updateValue(key, updated)
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of the member of the sorted set
+func (s *Store) ZScore(key, member string) (float64, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := zset.Score(member)
+	if !ok {
+		return 0, generateNotMemberError(key, member)
+	}
+	return score, nil
+}
+
+// ZRank returns the rank of the member of the sorted set where the member with
+// the lowest score has a rank of zero
+func (s *Store) ZRank(key, member string) (int, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	rank := zset.Rank(member)
+	if rank < 0 {
+		return 0, generateNotMemberError(key, member)
+	}
+	return rank, nil
+}
+
+// ZRange returns the members from the start to stop rank (inclusive). Negative
+// ranks are counted from the end of the sorted set so 0 to -1 returns every member
+func (s *Store) ZRange(key string, start, stop int) (ZSet, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return nil, err
+	}
+	start, stop = listRange(len(zset), start, stop)
+	return zset[start:stop:stop], nil
+}
+
+// ZRangeByScore returns the members with a score from min to max (inclusive)
+func (s *Store) ZRangeByScore(key string, min, max float64) (ZSet, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return nil, err
+	}
+	return zset.RangeByScore(min, max), nil
+}
+
+// ZIncrBy will add the delta to the score of the member returning the new score.
+// A member that does not exist is added with a score of zero first
+func (s *Store) ZIncrBy(key, member string, delta float64) (float64, error) {
+	zset, err := s.getZSet(key)
+	if err != nil {
+		return 0, err
+	}
+	score, _ := zset.Score(member)
+	score += delta
+	updated, _ := zset.update(ZMember{member, score})
+	s.updateValue(key, updated)
+	return score, nil
+}
+
+// zset will apply the ZSET operation of the request
+func (sh *shard) zset(request *Request, response *Response) {
+	var err error
+	switch request.Op {
+	case ZADD:
+		members, ok := toZSet(request.Value.Val)
+		if !ok {
+			err = generateError(Invalid, "The members must have a score")
+			break
+		}
+		var added int
+		if added, err = sh.store.ZAdd(request.Key, members...); err == nil {
+			if len(members) > 0 {
+				sh.changed(request.Key)
+			}
+			response.Value = &ValueHolder{Type: INT, Val: added}
+		}
+	case ZREM:
+		members, ok := toSet(request.Value.Val)
+		if !ok {
+			err = generateError(Invalid, "The members must be strings")
+			break
+		}
+		var removed int
+		if removed, err = sh.store.ZRem(request.Key, members...); err == nil {
+			if removed > 0 {
+				sh.changed(request.Key)
+			}
+			response.Value = &ValueHolder{Type: INT, Val: removed}
+		}
+	case ZSCORE:
+		member, _ := request.Value.Val.(string)
+		var score float64
+		if score, err = sh.store.ZScore(request.Key, member); err == nil {
+			response.Value = &ValueHolder{Type: FLOAT, Val: score}
+		}
+	case ZRANK:
+		member, _ := request.Value.Val.(string)
+		var rank int
+		if rank, err = sh.store.ZRank(request.Key, member); err == nil {
+			response.Value = &ValueHolder{Type: INT, Val: rank}
+		}
+	case ZRANGE, ZRANGEBYSCORE:
+		var zset ZSet
+		if request.Op == ZRANGE {
+			zset, err = sh.store.ZRange(request.Key, request.Start, request.Stop)
+		} else {
+			zset, err = sh.store.ZRangeByScore(request.Key, request.Min, request.Max)
+		}
+		if err == nil {
+			response.Value = &ValueHolder{Type: ZSET, Val: zset}
+		}
+	case ZINCRBY:
+		increment, ok := request.Value.Val.(ZMember)
+		if !ok {
+			err = generateError(Invalid, "The increment must be a member and a score")
+			break
+		}
+		var score float64
+		if score, err = sh.store.ZIncrBy(request.Key, increment.Member, increment.Score); err == nil {
+			sh.changed(request.Key)
+			response.Value = &ValueHolder{Type: FLOAT, Val: score}
+		}
+	}
+	if err == nil {
+		response.Version = sh.store.Version(request.Key)
+		response.Success = true
+	} else {
+		response.fail(err)
+	}
+}